@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// (NOTE: This is returned whenever someone is too young to use AKTVE, so that
+// endpoints can tell them so.)
+var errUserTooYoung = errors.New("user is not old enough")
+
+// ParseBirthdate parses a birthdate in the "YYYY-MM-DD" format, as entered by
+// a User of AKTVE.
+func ParseBirthdate(value string) (time.Time, error) {
+	birthdate, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New("age: birthdate must be in the YYYY-MM-DD format")
+	}
+
+	now := time.Now()
+	if birthdate.After(now) || AgeOn(birthdate, now) > 120 {
+		return time.Time{}, errors.New("age: birthdate is not plausible")
+	}
+
+	return birthdate, nil
+}
+
+// ParseOptionalBirthdate parses a birthdate like ParseBirthdate does, but
+// returns nil (and no error) if no birthdate was entered at all.
+func ParseOptionalBirthdate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	birthdate, err := ParseBirthdate(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &birthdate, nil
+}
+
+// AgeOn returns how old someone born on the provided birthdate is (in whole
+// years) on the provided day. (NOTE: Birthdates are stored as midnight UTC, so
+// days are compared in UTC.)
+func AgeOn(birthdate time.Time, now time.Time) int {
+	birthdate = birthdate.UTC()
+	now = now.UTC()
+
+	age := now.Year() - birthdate.Year()
+	if now.Month() < birthdate.Month() || (now.Month() == birthdate.Month() && now.Day() < birthdate.Day()) {
+		age--
+	}
+
+	return age
+}
+
+// IsOldEnough returns whether someone born on the provided birthdate is at
+// least the minimum age for AKTVE.
+func IsOldEnough(birthdate time.Time) bool {
+	return AgeOn(birthdate, time.Now()) >= gMinimumAge
+}
+
+// BirthdateRange returns the range of birthdates that people aged between the
+// provided minimum and maximum ages (inclusive) on the provided day were born
+// in. A birthdate is in the range if it is after the returned earliest time and
+// no later than the returned latest time.
+func BirthdateRange(minAge int, maxAge int, now time.Time) (time.Time, time.Time) {
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	earliest := today.AddDate(-(maxAge + 1), 0, 0)
+	latest := today.AddDate(-minAge, 0, 0)
+
+	return earliest, latest
+}
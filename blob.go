@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BlobStore is an interface for the places that the contents of Files can be
+// stored in. Blobs are addressed by a key (the hex representation of their
+// File's ID), and Files themselves are just metadata records in the database.
+type BlobStore interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	Check() error // Checks that blobs can currently be stored and retrieved
+}
+
+var gBlobStore BlobStore
+
+// NewBlobStore creates the BlobStore of the provided type ("filesystem",
+// "gridfs" or "s3"), configured from the blob storage settings.
+func NewBlobStore(storeType string) (BlobStore, error) {
+	switch storeType {
+	case "filesystem":
+		return &FilesystemBlobStore{Root: gBlobStorePath}, nil
+	case "gridfs":
+		return &GridFSBlobStore{Prefix: "fs"}, nil
+	case "s3":
+		return &S3BlobStore{
+			Endpoint:  gS3Endpoint,
+			Region:    gS3Region,
+			Bucket:    gS3Bucket,
+			AccessKey: gS3AccessKey,
+			SecretKey: gS3SecretKey,
+			Client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+
+	return nil, errors.New("blob: unknown blob store type " + storeType)
+}
+
+// FilesystemBlobStore is a BlobStore that keeps blobs as files on the local
+// filesystem, underneath the Root directory.
+type FilesystemBlobStore struct {
+	Root string
+}
+
+// path returns the path of the blob with the provided key. Blobs are spread
+// out into subdirectories by the first two characters of their key, so that no
+// single directory gets too large.
+func (o *FilesystemBlobStore) path(key string) (string, error) {
+	if len(key) < 2 || strings.ContainsAny(key, "/\\.") {
+		return "", errors.New("blob: invalid key")
+	}
+
+	return filepath.Join(o.Root, key[:2], key), nil
+}
+
+// Put stores the provided data as the blob with the provided key.
+func (o *FilesystemBlobStore) Put(key string, data []byte, contentType string) error {
+	path, err := o.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return errors.New("blob: failed to create directory")
+	}
+
+	// Write the blob to a temporary file first, so that a half-written blob is
+	// never visible under its key
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+key)
+	if err != nil {
+		return errors.New("blob: failed to create file")
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return errors.New("blob: failed to write file")
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return errors.New("blob: failed to write file")
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return errors.New("blob: failed to write file")
+	}
+
+	return nil
+}
+
+// Get retrieves the data of the blob with the provided key.
+func (o *FilesystemBlobStore) Get(key string) ([]byte, error) {
+	path, err := o.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("blob: failed to read file")
+	}
+
+	return data, nil
+}
+
+// Delete removes the blob with the provided key (if it exists).
+func (o *FilesystemBlobStore) Delete(key string) error {
+	path, err := o.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.New("blob: failed to remove file")
+	}
+
+	return nil
+}
+
+// Check makes sure that the Root directory exists and can be written to.
+func (o *FilesystemBlobStore) Check() error {
+	if info, err := os.Stat(o.Root); err != nil || !info.IsDir() {
+		return errors.New("blob: root directory does not exist")
+	}
+
+	file, err := ioutil.TempFile(o.Root, ".check-")
+	if err != nil {
+		return errors.New("blob: root directory is not writable")
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	return nil
+}
+
+// GridFSBlobStore is a BlobStore that keeps blobs in the database's GridFS,
+// which splits them into chunks so that they aren't limited by the maximum
+// document size.
+type GridFSBlobStore struct {
+	Prefix string
+}
+
+// Put stores the provided data as the blob with the provided key.
+func (o *GridFSBlobStore) Put(key string, data []byte, contentType string) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	gridFS := db.DB(dbDB).GridFS(o.Prefix)
+
+	file, err := gridFS.Create(key)
+	if err != nil {
+		return errors.New("blob: failed to create GridFS file")
+	}
+	file.SetContentType(contentType)
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return errors.New("blob: failed to write GridFS file")
+	}
+	if err := file.Close(); err != nil {
+		return errors.New("blob: failed to write GridFS file")
+	}
+
+	return nil
+}
+
+// Get retrieves the data of the blob with the provided key.
+func (o *GridFSBlobStore) Get(key string) ([]byte, error) {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	gridFS := db.DB(dbDB).GridFS(o.Prefix)
+
+	file, err := gridFS.Open(key)
+	if err != nil {
+		return nil, errors.New("blob: failed to open GridFS file")
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, errors.New("blob: failed to read GridFS file")
+	}
+
+	return data, nil
+}
+
+// Delete removes the blob with the provided key (if it exists).
+func (o *GridFSBlobStore) Delete(key string) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	gridFS := db.DB(dbDB).GridFS(o.Prefix)
+
+	if err := gridFS.Remove(key); err != nil && err != mgo.ErrNotFound {
+		return errors.New("blob: failed to remove GridFS file")
+	}
+
+	return nil
+}
+
+// Check makes sure that the GridFS collections can be queried.
+func (o *GridFSBlobStore) Check() error {
+	var file bson.M
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	if err := db.DB(dbDB).C(o.Prefix + ".files").Find(nil).One(&file); err != nil && err != mgo.ErrNotFound {
+		return errors.New("blob: failed to query GridFS")
+	}
+
+	return nil
+}
+
+// S3BlobStore is a BlobStore that keeps blobs in a bucket of an S3-compatible
+// object storage service (e.g. Amazon S3 or MinIO). Requests are made with
+// path-style addressing and signed with AWS Signature Version 4.
+type S3BlobStore struct {
+	Endpoint  string // e.g. "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// Put stores the provided data as the blob with the provided key.
+func (o *S3BlobStore) Put(key string, data []byte, contentType string) error {
+	res, err := o.do("PUT", key, data, contentType)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("blob: failed to put S3 object (" + res.Status + ")")
+	}
+
+	return nil
+}
+
+// Get retrieves the data of the blob with the provided key.
+func (o *S3BlobStore) Get(key string) ([]byte, error) {
+	res, err := o.do("GET", key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("blob: failed to get S3 object (" + res.Status + ")")
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.New("blob: failed to read S3 object")
+	}
+
+	return data, nil
+}
+
+// Delete removes the blob with the provided key (if it exists).
+func (o *S3BlobStore) Delete(key string) error {
+	res, err := o.do("DELETE", key, nil, "")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return errors.New("blob: failed to delete S3 object (" + res.Status + ")")
+	}
+
+	return nil
+}
+
+// Check makes sure that the bucket exists and that the credentials can access
+// it.
+func (o *S3BlobStore) Check() error {
+	res, err := o.do("HEAD", "", nil, "")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.New("blob: failed to access S3 bucket (" + res.Status + ")")
+	}
+
+	return nil
+}
+
+// do sends a signed request for the object with the provided key to the S3
+// endpoint.
+func (o *S3BlobStore) do(method string, key string, body []byte, contentType string) (*http.Response, error) {
+	url := strings.TrimRight(o.Endpoint, "/") + "/" + o.Bucket + "/" + key
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, errors.New("blob: failed to create S3 request")
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	o.sign(req, body, time.Now().UTC())
+
+	res, err := o.Client.Do(req)
+	if err != nil {
+		return nil, errors.New("blob: failed to send S3 request")
+	}
+
+	return res, nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header to the provided
+// request (which has the provided body) as of the provided time.
+func (o *S3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := HashData(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Build up the canonical representation of the request
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		signedHeaders = "content-type;" + signedHeaders
+		canonicalHeaders = "content-type:" + contentType + "\n" + canonicalHeaders
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	// Sign it with a key derived from the secret key and the request's scope
+	scope := date + "/" + o.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + HashData([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+o.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, o.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+o.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the provided data with the provided
+// key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// FakeS3Server is a stand-in for an S3-compatible object storage service, for
+// local testing. It keeps objects in memory, serves a single bucket with
+// path-style addressing, and checks that requests are signed with the
+// configured credentials. (NOTE: This must never be used in production.)
+type FakeS3Server struct {
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+// ServeHTTP handles the HEAD bucket, and PUT, GET and DELETE object requests.
+func (o *FakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !o.verify(r, body) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != o.Bucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.objects == nil {
+		o.objects = map[string][]byte{}
+	}
+
+	switch {
+	case r.Method == "HEAD" && key == "":
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case r.Method == "PUT":
+		o.objects[key] = body
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "GET":
+		data, ok := o.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	case r.Method == "DELETE":
+		delete(o.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Keys returns the keys of every object that is currently stored.
+func (o *FakeS3Server) Keys() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	keys := []string{}
+	for key := range o.objects {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// verify returns whether the provided request (which has the provided body) is
+// signed with the configured credentials, by signing it again and comparing
+// the signatures.
+func (o *FakeS3Server) verify(r *http.Request, body []byte) bool {
+	if r.Header.Get("X-Amz-Content-Sha256") != HashData(body) {
+		return false
+	}
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return false
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	store := &S3BlobStore{Region: o.Region, AccessKey: o.AccessKey, SecretKey: o.SecretKey}
+	store.sign(req, body, now)
+
+	return hmac.Equal([]byte(req.Header.Get("Authorization")), []byte(r.Header.Get("Authorization")))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeS3BlobStore returns an S3BlobStore that talks to a new FakeS3Server
+// (which is closed once the test is over), signing its requests with the
+// provided secret key.
+func newFakeS3BlobStore(t *testing.T, secretKey string) (*S3BlobStore, *FakeS3Server) {
+	fake := &FakeS3Server{Region: "us-east-1", Bucket: "aktve-files", AccessKey: "test-access", SecretKey: "test-secret"}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store := &S3BlobStore{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "aktve-files",
+		AccessKey: "test-access",
+		SecretKey: secretKey,
+		Client:    &http.Client{},
+	}
+
+	return store, fake
+}
+
+func TestS3BlobStoreRoundTrip(t *testing.T) {
+	store, fake := newFakeS3BlobStore(t, "test-secret")
+
+	if err := store.Check(); err != nil {
+		t.Fatalf("Check returned an error: %v", err)
+	}
+
+	data := []byte("not really a jpeg")
+	if err := store.Put("0123456789abcdef", data, "image/jpeg"); err != nil {
+		t.Fatalf("Put returned an error: %v", err)
+	}
+	if got, err := store.Get("0123456789abcdef"); err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	} else if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %q, expected %q", got, data)
+	}
+
+	if err := store.Delete("0123456789abcdef"); err != nil {
+		t.Fatalf("Delete returned an error: %v", err)
+	}
+	if keys := fake.Keys(); len(keys) != 0 {
+		t.Errorf("Delete left %v behind", keys)
+	}
+	if _, err := store.Get("0123456789abcdef"); err == nil {
+		t.Error("Get returned a deleted blob")
+	}
+
+	// Deleting a blob that doesn't exist isn't an error
+	if err := store.Delete("0123456789abcdef"); err != nil {
+		t.Errorf("Delete of a missing blob returned an error: %v", err)
+	}
+}
+
+func TestS3BlobStoreRejectsWrongSecretKey(t *testing.T) {
+	store, fake := newFakeS3BlobStore(t, "wrong-secret")
+
+	if err := store.Check(); err == nil {
+		t.Error("Check succeeded with the wrong secret key")
+	}
+	if err := store.Put("0123456789abcdef", []byte("data"), "image/jpeg"); err == nil {
+		t.Error("Put succeeded with the wrong secret key")
+	}
+	if keys := fake.Keys(); len(keys) != 0 {
+		t.Errorf("Put with the wrong secret key stored %v", keys)
+	}
+}
+
+func TestS3BlobStoreCheckFailsForMissingBucket(t *testing.T) {
+	store, _ := newFakeS3BlobStore(t, "test-secret")
+	store.Bucket = "some-other-bucket"
+
+	if err := store.Check(); err == nil {
+		t.Error("Check succeeded for a bucket that doesn't exist")
+	}
+}
+
+func TestFilesystemBlobStoreRoundTrip(t *testing.T) {
+	store := &FilesystemBlobStore{Root: t.TempDir()}
+
+	if err := store.Check(); err != nil {
+		t.Fatalf("Check returned an error: %v", err)
+	}
+
+	data := []byte("not really a jpeg")
+	if err := store.Put("0123456789abcdef", data, "image/jpeg"); err != nil {
+		t.Fatalf("Put returned an error: %v", err)
+	}
+	if got, err := store.Get("0123456789abcdef"); err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	} else if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %q, expected %q", got, data)
+	}
+
+	if err := store.Delete("0123456789abcdef"); err != nil {
+		t.Fatalf("Delete returned an error: %v", err)
+	}
+	if _, err := store.Get("0123456789abcdef"); err == nil {
+		t.Error("Get returned a deleted blob")
+	}
+
+	if err := store.Put("../escape", data, "image/jpeg"); err == nil {
+		t.Error("Put accepted a key outside of the root directory")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// These routes don't need the database, so they keep being served while it is
+// down (which is what lets load balancers and monitoring see that it is).
+var gDatabaseFreeRoutes = map[string]bool{
+	"GETIndex":   true,
+	"GETMetrics": true,
+	"GETHealthz": true,
+	"GETReadyz":  true,
+	"GETStatus":  true,
+}
+
+// CircuitBreaker spins off a new endpoint handler, as specified, unless the
+// database is down and the endpoint needs it. In that case, the client is
+// sent a 503 response straight away, rather than waiting for the request to
+// time out against the database.
+func CircuitBreaker(inner http.Handler, name string) http.Handler {
+	if gDatabaseFreeRoutes[name] {
+		return inner
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gDatabase.IsUp() {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		// Respond in the same shape as every other API call
+		returnData := struct {
+			Success Success
+		}{Success{Success: false, Error: "The API is temporarily unavailable. Please try again shortly."}}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Header().Set("Retry-After", strconv.Itoa(int(gDatabaseCheckInterval.Seconds())+1))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(returnData)
+	})
+}
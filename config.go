@@ -1,4 +1,164 @@
 package main
 
+import (
+	"crypto/rand"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
 var gAPIVersion float32 = 1.4
 var gAPIURL = "https://api.aktve-app.com"
+
+// Declare some like quota and swipe rate limiting settings
+var gDailyLikeQuota = configInt("AKTVE_DAILY_LIKE_QUOTA", 100)                              // The number of Likes a User may give per day
+var gDailySuperLikeQuota = configInt("AKTVE_DAILY_SUPERLIKE_QUOTA", 1)                      // The number of super-likes a User may give per day
+var gSwipeVelocityWindow = configDuration("AKTVE_SWIPE_VELOCITY_WINDOW", 10*time.Second)    // The window that swipe velocity is measured over
+var gSwipeVelocityLimit = configInt("AKTVE_SWIPE_VELOCITY_LIMIT", 20)                       // The number of swipes allowed within the window above
+var gSwipeThrottleDuration = configDuration("AKTVE_SWIPE_THROTTLE_DURATION", 5*time.Minute) // How long a User is throttled for after swiping too fast
+
+// Declare some monitoring settings
+var gLogLevel = configString("AKTVE_LOG_LEVEL", "info")                          // The least severe level that is logged ("debug", "info", "warn" or "error")
+var gMetricsToken = configString("AKTVE_METRICS_TOKEN", "")                      // The bearer token that "GET /metrics" requires (disabled if not set)
+var gReadinessTimeout = configDuration("AKTVE_READINESS_TIMEOUT", 2*time.Second) // How long each dependency has to respond to "GET /readyz"
+
+// Declare some HTTP server settings
+var gHTTPAddress = configString("AKTVE_HTTP_ADDRESS", ":8080")                               // The address that plain HTTP is served on (which only redirects to HTTPS if TLS is enabled)
+var gHTTPReadTimeout = configDuration("AKTVE_HTTP_READ_TIMEOUT", 30*time.Second)             // How long clients have to send a whole request (including uploads)
+var gHTTPReadHeaderTimeout = configDuration("AKTVE_HTTP_READ_HEADER_TIMEOUT", 5*time.Second) // How long clients have to send a request's headers
+var gHTTPWriteTimeout = configDuration("AKTVE_HTTP_WRITE_TIMEOUT", 60*time.Second)           // How long a response has to be written
+var gHTTPIdleTimeout = configDuration("AKTVE_HTTP_IDLE_TIMEOUT", 2*time.Minute)              // How long keep-alive connections are kept open between requests
+var gHTTPMaxHeaderBytes = configInt("AKTVE_HTTP_MAX_HEADER_BYTES", 64<<10)                   // The largest size that a request's headers may be
+var gShutdownTimeout = configDuration("AKTVE_SHUTDOWN_TIMEOUT", 30*time.Second)              // How long requests in flight have to finish when shutting down
+
+// Declare some TLS settings (NOTE: TLS is only enabled if a certificate and key
+// are provided.)
+var gTLSCertFile = configString("AKTVE_TLS_CERT_FILE", "")                        // The path of the TLS certificate (including any intermediates)
+var gTLSKeyFile = configString("AKTVE_TLS_KEY_FILE", "")                          // The path of the TLS certificate's private key
+var gHTTPSAddress = configString("AKTVE_HTTPS_ADDRESS", ":8443")                  // The address that HTTPS is served on
+var gTLSRedirect = configBool("AKTVE_TLS_REDIRECT", true)                         // Whether plain HTTP is still served, to redirect to HTTPS
+var gTLSRedirectURL = configString("AKTVE_TLS_REDIRECT_URL", "")                  // The HTTPS URL that plain HTTP is redirected to (the API URL on the HTTPS port if not set)
+var gTLSReloadInterval = configDuration("AKTVE_TLS_RELOAD_INTERVAL", time.Minute) // How often the certificate files are checked for changes
+var gHSTSMaxAge = configDuration("AKTVE_HSTS_MAX_AGE", 365*24*time.Hour)          // How long clients should only use HTTPS for (0 to not send HSTS)
+
+// Declare some database settings
+var gDatabaseCheckInterval = configDuration("AKTVE_DATABASE_CHECK_INTERVAL", 5*time.Second) // How often the connection to the database is checked
+var gDatabasePingTimeout = configDuration("AKTVE_DATABASE_PING_TIMEOUT", 5*time.Second)     // How long the database has to respond to a check
+var gDatabaseMaxBackoff = configDuration("AKTVE_DATABASE_MAX_BACKOFF", time.Minute)         // The longest to wait between attempts to reconnect to the database
+
+// Declare some age settings
+var gMinimumAge = configInt("AKTVE_MINIMUM_AGE", 18) // How old Users must be to use AKTVE
+
+// Declare some account deletion settings
+var gAccountDeletionGracePeriod = configDuration("AKTVE_ACCOUNT_DELETION_GRACE_PERIOD", 14*24*time.Hour) // How long a User has to cancel the deletion of their account
+
+// Declare some image upload settings
+var gMaxImageUploadSize = configInt("AKTVE_MAX_IMAGE_UPLOAD_SIZE", 10*1024*1024) // The maximum size of an uploaded image, in bytes
+var gMaxImagePixels = configInt("AKTVE_MAX_IMAGE_PIXELS", 40*1000*1000)          // The maximum number of pixels in an uploaded image
+var gMaxUserImages = configInt("AKTVE_MAX_USER_IMAGES", 6)                       // The maximum number of images a User may have
+
+// Declare some blob storage settings
+var gBlobStoreType = configString("AKTVE_BLOB_STORE", "gridfs")                    // Where file contents are stored ("filesystem", "gridfs" or "s3")
+var gBlobStorePath = configString("AKTVE_BLOB_STORE_PATH", "/var/lib/aktve/files") // The directory that the "filesystem" blob store uses
+var gS3Endpoint = configString("AKTVE_S3_ENDPOINT", "https://s3.amazonaws.com")    // The endpoint of the "s3" blob store
+var gS3Region = configString("AKTVE_S3_REGION", "us-east-1")
+var gS3Bucket = configString("AKTVE_S3_BUCKET", "aktve-files")
+var gS3AccessKey = configString("AKTVE_S3_ACCESS_KEY", "")
+var gS3SecretKey = configString("AKTVE_S3_SECRET_KEY", "")
+
+// Declare some file URL signing settings
+var gFileURLSecret = configSecret("AKTVE_FILE_URL_SECRET")                     // The key that file URLs are signed with
+var gFileURLLifetime = configDuration("AKTVE_FILE_URL_LIFETIME", 24*time.Hour) // How long signed file URLs are valid for
+
+// Declare some image moderation settings
+var gModerationAutoApprove = configBool("AKTVE_MODERATION_AUTO_APPROVE", false) // Whether images that pass pre-screening skip the moderation queue
+
+// Declare some identity provider settings
+var gAppleClientID = configString("AKTVE_APPLE_CLIENT_ID", "")                            // The app's bundle ID, for Sign in with Apple (disabled if not set)
+var gGoogleClientID = configString("AKTVE_GOOGLE_CLIENT_ID", "")                          // The app's OAuth client ID, for Google Sign-In (disabled if not set)
+var gFakeIdentityProvider = configBool("AKTVE_FAKE_IDENTITY_PROVIDER", false)             // Whether anyone may log in as anyone, for local testing
+var gEmailLoginURL = configString("AKTVE_EMAIL_LOGIN_URL", "https://aktve-app.com/login") // Where emailed login links point to
+var gEmailTokenLifetime = configDuration("AKTVE_EMAIL_TOKEN_LIFETIME", 15*time.Minute)    // How long emailed login links are valid for
+
+// Declare some password authentication settings
+var gBcryptCost = configInt("AKTVE_BCRYPT_COST", 12)                                                     // How much work hashing a password takes
+var gPasswordMaxAttempts = configInt("AKTVE_PASSWORD_MAX_ATTEMPTS", 5)                                   // How many wrong passwords in a row lock an account
+var gPasswordLockoutDuration = configDuration("AKTVE_PASSWORD_LOCKOUT_DURATION", 15*time.Minute)         // How long an account stays locked for
+var gEmailVerifyURL = configString("AKTVE_EMAIL_VERIFY_URL", "https://aktve-app.com/verify")             // Where emailed verification links point to
+var gPasswordResetURL = configString("AKTVE_PASSWORD_RESET_URL", "https://aktve-app.com/reset-password") // Where emailed password reset links point to
+
+// Declare some Facebook settings
+var gFacebookAppID = configString("AKTVE_FACEBOOK_APP_ID", "")                                       // Required to check that access tokens were issued to AKTVE (unless the fake Graph API is used)
+var gFacebookAppSecret = configString("AKTVE_FACEBOOK_APP_SECRET", "")                               // Required for "appsecret_proof" and to check access tokens (unless the fake Graph API is used)
+var gFacebookGraphURL = configString("AKTVE_FACEBOOK_GRAPH_URL", "https://graph.facebook.com/v19.0") // Where the Graph API is
+var gFakeFacebookGraphAddress = configString("AKTVE_FAKE_FACEBOOK_GRAPH_ADDRESS", "")                // Where to serve a fake Graph API for local testing (disabled if not set)
+
+// Declare some email settings
+var gSMTPAddress = configString("AKTVE_SMTP_ADDRESS", "") // The "host:port" of the SMTP server (emails are only logged if not set)
+var gSMTPUsername = configString("AKTVE_SMTP_USERNAME", "")
+var gSMTPPassword = configString("AKTVE_SMTP_PASSWORD", "")
+var gMailFrom = configString("AKTVE_MAIL_FROM", "AKTVE <no-reply@aktve-app.com>")
+var gMailLogFull = configBool("AKTVE_MAIL_LOG_FULL", false) // Whether logged emails include their recipient and links (at debug level), for local testing
+
+// configString returns the value of the environment variable with the provided
+// key, or the provided default value if it is not set.
+func configString(key string, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return def
+}
+
+// configInt returns the value of the environment variable with the provided
+// key as an int, or the provided default value if it is not set or is not a
+// valid number.
+func configInt(key string, def int) int {
+	if num, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return num
+	}
+
+	return def
+}
+
+// configBool returns the value of the environment variable with the provided
+// key as a bool, or the provided default value if it is not set or is not a
+// valid bool.
+func configBool(key string, def bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+
+	return def
+}
+
+// configDuration returns the value of the environment variable with the
+// provided key as a duration (e.g. "90s"), or the provided default value if it
+// is not set or is not a valid duration.
+func configDuration(key string, def time.Duration) time.Duration {
+	if duration, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return duration
+	}
+
+	return def
+}
+
+// configSecret returns the value of the environment variable with the provided
+// key as a secret key. If it is not set, a random key is generated instead
+// (which means that anything signed with it won't survive a restart, and won't
+// be accepted by any other instance of the API server).
+func configSecret(key string) []byte {
+	if value := os.Getenv(key); value != "" {
+		return []byte(value)
+	}
+
+	slog.Warn("config: " + key + " is not set, so a random key will be used instead")
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return secret
+}
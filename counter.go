@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Counter is a struct representing a named sequence in the database that is
+// used to allocate unique IDs (e.g. for Users and Likes).
+type Counter struct {
+	Name string `bson:"_id"`
+	Seq  int    `bson:"seq"`
+}
+
+// NextID atomically allocates and returns the next ID in the sequence with the
+// provided name. Unlike counting the documents in a collection, this never
+// hands out the same ID twice, even when requests race or documents are
+// deleted.
+func NextID(name string) (int, error) {
+	var counter Counter
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("counters")
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	if _, err := c.FindId(name).Apply(change, &counter); err != nil {
+		return -1, errors.New("counter: failed to allocate new ID")
+	}
+
+	return counter.Seq, nil
+}
+
+// SeedCounter makes sure that the sequence with the provided name will only
+// allocate IDs higher than any "id" already used in the provided collection.
+// This allows existing data (whose IDs were allocated by counting) to be
+// carried over.
+func SeedCounter(name string, collection string) error {
+	var highest struct {
+		ID int `bson:"id"`
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	// Find the highest ID currently in the collection (if the collection is
+	// empty, seed the sequence so that the first ID allocated is 0)
+	c := db.DB(dbDB).C(collection)
+	if err := c.Find(nil).Sort("-id").One(&highest); err == mgo.ErrNotFound {
+		highest.ID = -1
+	} else if err != nil {
+		return errors.New("counter: failed to find highest ID in " + collection)
+	}
+
+	// Raise the sequence to the highest ID if it is not already past it
+	c = db.DB(dbDB).C("counters")
+	if _, err := c.Upsert(bson.M{"_id": name}, bson.M{"$max": bson.M{"seq": highest.ID}}); err != nil {
+		return errors.New("counter: failed to seed " + name)
+	}
+
+	return nil
+}
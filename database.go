@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -15,13 +17,17 @@ const dbPass = "AktveServerPass"  // The password for the user above
 const dbDB = "aktve"
 
 // Database is a light wrapper struct for an MGo MongoDB database session
-// object.
+// object. (NOTE: The session is never used directly to talk to the database.
+// Each operation works on its own copy of it (see Copy), so that a broken
+// connection only breaks the operations that were using it.)
 type Database struct {
 	db *mgo.Session
+	up atomic.Bool // Whether the database was reachable when last checked
 }
 
 // DatabaseConnect attempts to dial the database and cache a new session with
-// it.
+// it. If the database can't be reached, this keeps retrying (backing off
+// exponentially) until it can, rather than giving up.
 func (o *Database) DatabaseConnect() error {
 	// If we are connected to the database, disconnected
 	if o.DatabaseTest() != nil {
@@ -37,17 +43,188 @@ func (o *Database) DatabaseConnect() error {
 		Password: dbPass,
 	}
 	//session, err := mgo.Dial("mongodb://" + dbUser + ":" + dbPass + "@" + dbHost + "/" + dbDB)
+	backoff := time.Second
 	session, err := mgo.DialWithInfo(dialInfo)
-	if err != nil {
-		panic(err)
+	for err != nil {
+		slog.Error("database: failed to connect, retrying", "error", err, "retry_in", backoff.String())
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff)
+
+		session, err = mgo.DialWithInfo(dialInfo)
 	}
 
 	// Cache the new session
 	o.db = session
+	o.setUp(true)
+
+	// Make sure that the collections are indexed as we expect them to be
+	o.DatabaseEnsureIndexes()
 
 	return nil
 }
 
+// DatabaseEnsureIndexes creates any indexes that the API server relies on (such
+// as the unique indexes that keep IDs and Likes from being duplicated) if they
+// do not yet exist, and seeds the ID counters from any existing data.
+func (o *Database) DatabaseEnsureIndexes() {
+	// Make sure the ID counters are ahead of any IDs that are already in use
+	if err := SeedCounter("users", "users"); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+	if err := SeedCounter("likes", "likes"); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+
+	// Copy any Facebook links over from before there were other identity
+	// providers
+	if err := MigrateFacebookLinks(); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+
+	// Remove any access tokens stored from before they stopped being kept
+	if err := RemoveIdentityTokens(); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+
+	// Log out anyone who registered without verifying their email address
+	if err := RevokeUnverifiedSessions(); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+
+	// Work out how far through onboarding any Users from before onboarding are
+	if err := MigrateOnboarding(); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+
+	// Clean up any duplicate Likes that were created before Likes were unique
+	if err := RemoveDuplicateLikes(); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+	if err := RenumberDuplicateLikes(); err != nil {
+		slog.Error("database: " + err.Error())
+	}
+
+	// Create the indexes (NOTE: Duplicate User IDs cannot be fixed
+	// automatically, as they are referenced everywhere. If the "users" index
+	// fails to be created, the duplicates will need to be resolved by hand.)
+	indexes := map[string][]mgo.Index{
+		"users": {
+			{Key: []string{"id"}, Unique: true},
+			{Key: []string{"onboarding"}},
+		},
+		"likes": {
+			{Key: []string{"id"}, Unique: true},
+			{Key: []string{"liker_id", "likee_id"}, Unique: true},
+		},
+		"like_quotas": {
+			{Key: []string{"expires"}, ExpireAfter: time.Second},
+		},
+		"sessions": {
+			{Key: []string{"token"}, Unique: true},
+		},
+		"fb_links": {
+			{Key: []string{"fb_user_id"}, Unique: true},
+		},
+		"identity_links": {
+			{Key: []string{"provider", "subject"}, Unique: true},
+			{Key: []string{"user_id"}},
+		},
+		"email_tokens": {
+			{Key: []string{"expires"}, ExpireAfter: time.Second},
+		},
+		"files": {
+			{Key: []string{"sha256"}},
+			{Key: []string{"rendition_of"}},
+			{Key: []string{"moderation"}},
+		},
+	}
+	for collection, collectionIndexes := range indexes {
+		c := o.db.DB(dbDB).C(collection)
+		for _, index := range collectionIndexes {
+			if err := c.EnsureIndex(index); err != nil {
+				slog.Error("database: failed to ensure index", "collection", collection, "index", index.Key, "error", err)
+			}
+		}
+	}
+}
+
+// Copy returns a new session with the database, which has its own connection
+// but shares the original session's settings. The caller must close it when
+// they are done with it. (e.g. "db := gDatabase.Copy()" followed by
+// "defer db.Close()")
+func (o *Database) Copy() *mgo.Session {
+	return o.db.Copy()
+}
+
+// IsUp returns whether the database was reachable when it was last checked.
+func (o *Database) IsUp() bool {
+	return o.up.Load()
+}
+
+// setUp records whether the database is reachable.
+func (o *Database) setUp(up bool) {
+	o.up.Store(up)
+	if up {
+		gDatabaseUp.Set(1)
+	} else {
+		gDatabaseUp.Set(0)
+	}
+}
+
+// DatabaseMonitor checks the connection to the database at the provided
+// interval, until the provided channel is closed. If the database stops being
+// reachable, this marks it as down (so that requests that need it fail fast)
+// and tries to reconnect, backing off exponentially, until it is reachable
+// again. It should be run as a goroutine.
+func (o *Database) DatabaseMonitor(interval time.Duration, stop <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+
+		err := o.DatabaseTest()
+		if err == nil {
+			continue
+		}
+
+		slog.Error("database: connection lost, reconnecting", "error", err)
+		o.setUp(false)
+
+		backoff := time.Second
+		for err != nil {
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			backoff = nextBackoff(backoff)
+
+			// (NOTE: Refreshing the session drops any connections it holds, so
+			// that new ones are made to whichever server is now the primary.)
+			o.db.Refresh()
+			if err = o.DatabaseTest(); err != nil {
+				slog.Error("database: failed to reconnect, retrying", "error", err, "retry_in", backoff.String())
+			}
+		}
+
+		slog.Info("database: reconnected")
+		o.setUp(true)
+	}
+}
+
+// nextBackoff returns how long to wait before the next retry, given how long
+// was waited before the last one.
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > gDatabaseMaxBackoff {
+		backoff = gDatabaseMaxBackoff
+	}
+
+	return backoff
+}
+
 // DatabaseDisconnect closes the current connection to the database.
 func (o *Database) DatabaseDisconnect() {
 	// See if we have a session to work with
@@ -64,8 +241,15 @@ func (o *Database) DatabaseTest() error {
 		return errors.New("database: session not dialed")
 	}
 
-	// Ping the database to see if it's there
-	err := o.db.Ping()
+	// Ping the database to see if it's there (NOTE: This is done on a fresh
+	// copy of the session, with short timeouts, so that it reflects whether
+	// new operations would succeed and doesn't hang for long if not.)
+	session := o.db.Copy()
+	defer session.Close()
+	session.SetSyncTimeout(gDatabasePingTimeout)
+	session.SetSocketTimeout(gDatabasePingTimeout)
+
+	err := session.Ping()
 
 	return err
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// EmailToken is a struct representing a single-use token that has been emailed
+// to a User of AKTVE (e.g. a login link). Only the hash of the token itself is
+// stored, so the database can't be used to log in as anyone.
+type EmailToken struct {
+	Hash    string    `bson:"_id"`
+	Email   string    `bson:"email"`
+	Purpose string    `bson:"purpose"` // What the token may be used for (e.g. "login")
+	Expires time.Time `bson:"expires"`
+}
+
+// NormaliseEmail returns the provided email address in the form that it is
+// stored and looked up in.
+func NormaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CreateEmailToken creates a new single-use token for the provided email
+// address and purpose that expires after the provided lifetime, and returns
+// it so that it can be emailed.
+func CreateEmailToken(email string, purpose string, lifetime time.Duration) (string, error) {
+	token := GenerateToken()
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("email_tokens")
+	if err := c.Insert(EmailToken{Hash: HashData([]byte(token)), Email: NormaliseEmail(email), Purpose: purpose, Expires: time.Now().Add(lifetime)}); err != nil {
+		return "", errors.New("failed to store email token")
+	}
+
+	return token, nil
+}
+
+// ConsumeEmailToken uses up the provided token, provided it is for the
+// provided purpose and hasn't expired, and returns the email address that it
+// was sent to.
+func ConsumeEmailToken(token string, purpose string) (string, error) {
+	var emailToken EmailToken
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("email_tokens")
+	if _, err := c.Find(bson.M{"_id": HashData([]byte(token)), "purpose": purpose}).Apply(mgo.Change{Remove: true}, &emailToken); err != nil {
+		return "", errors.New("could not find email token")
+	}
+	if time.Now().After(emailToken.Expires) {
+		return "", errors.New("email token has expired")
+	}
+
+	return emailToken.Email, nil
+}
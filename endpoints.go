@@ -4,17 +4,17 @@
 package main
 
 import (
-	"crypto/md5"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	fb "github.com/huandu/facebook"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -29,7 +29,14 @@ func EndpointGETStatus(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	// Create the actual data response of the API call
+	// (NOTE: The API server is "degraded" if it can't reach the database, as
+	// nearly every endpoint needs it. This goes by the database monitor's last
+	// check, so that it never waits on the database. See "GET /readyz" for
+	// more detail.)
 	data := Status{Name: "AKTVE API Server", Status: "online", Version: gAPIVersion}
+	if !gDatabase.IsUp() {
+		data.Status = "degraded"
+	}
 	data.Update()
 
 	// Create a success response
@@ -68,116 +75,226 @@ func EndpointPOSTLogin(w http.ResponseWriter, r *http.Request) {
 	var returnData ReturnData
 
 	// Process the API call
-	fbAccessToken := r.FormValue("fb_access_token")
+	// (NOTE: Older versions of the app only know about Facebook, so that is
+	// the default identity provider.)
+	providerName := r.FormValue("provider")
+	if providerName == "" {
+		providerName = "facebook"
+	}
 
-	if fbAccessToken == "" {
+	if provider, err := GetIdentityProvider(providerName); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'provider' paramater must be a supported identity provider."
+	} else if r.ParseForm() != nil {
+		success.Success = false
+		success.Error = "Invalid API call. Could not parse form data."
+	} else if identity, err := provider.Authenticate(r.Form); err != nil {
 		success.Success = false
-		success.Error = "Invalid API call. 'fb_access_token' paramater is required."
+		success.Error = "Invalid credentials provided to API call. Could not identify User with '" + providerName + "'."
+	} else if userID, err := LoginWithIdentity(identity); err != nil {
+		success.Success = false
+		if err == errUserTooYoung {
+			success.Error = "Users must be at least " + strconv.Itoa(gMinimumAge) + " years old to use AKTVE."
+		} else {
+			success.Error = "Failed to log in or create new User."
+		}
 	} else {
-		var m bson.M
+		// Create the new Session for the user and return their new API access
+		// token
+		session, _ := gSessionCache.CreateSession(userID)
+		data.Token = session.Token
+	}
 
-		// Try to get the User from Facebook
-		if res, err := fb.Get(("/me"), fb.Params{
-			"fields":       []string{"id", "name", "picture.width(640)"},
-			"access_token": r.FormValue("fb_access_token"),
-		}); err != nil {
-			success.Success = false
-			success.Error = "Invalid `fb_access_token` provided to API call."
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPOSTLoginEmail handles the "POST /login/email" API endpoint.
+func EndpointPOSTLoginEmail(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if _, err := GetIdentityProvider("email"); err != nil {
+		success.Success = false
+		success.Error = "Logging in by email is not currently supported."
+	} else if !strings.Contains(r.FormValue("email"), "@") {
+		success.Success = false
+		success.Error = "Invalid API call. 'email' paramater must be a valid email address."
+	} else if err := SendEmailLoginLink(r.FormValue("email")); err != nil {
+		success.Success = false
+		success.Error = "Failed to send login link."
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPOSTRegister handles the "POST /register" API endpoint.
+func EndpointPOSTRegister(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		VerificationSent bool `json:"verification_sent"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if !strings.Contains(r.FormValue("email"), "@") {
+		success.Success = false
+		success.Error = "Invalid API call. 'email' paramater must be a valid email address."
+	} else if err := ValidatePassword(r.FormValue("password")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'password' paramater must be between 8 and 72 characters long."
+	} else if birthdate, err := ParseOptionalBirthdate(r.FormValue("birthdate")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'birthdate' paramater must be a valid date in the YYYY-MM-DD format."
+	} else if _, err := RegisterWithPassword(r.FormValue("email"), r.FormValue("password"), r.FormValue("name"), birthdate); err != nil {
+		success.Success = false
+		if err == errUserTooYoung {
+			success.Error = "Users must be at least " + strconv.Itoa(gMinimumAge) + " years old to use AKTVE."
 		} else {
-			// Get the scoped Facebook User ID provided by Facebook itself
-			fbUserID := -1
-			if str, ok := res["id"].(string); ok {
-				if val, err := strconv.Atoi(str); err == nil {
-					fbUserID = val
-				}
-			}
-			if fbUserID == -1 {
-				success.Success = false
-				success.Error = "Could not retrieve scoped Facebook User ID from Facebook."
-			} else {
-				// Attempt to get User from the database
-				c := gDatabase.db.DB(dbDB).C("fb_links")
-				if err := c.Find(bson.M{"fb_user_id": fbUserID}).One(&m); err != nil { // If the User is not linked in our database
-					// Calculate age based on birthday
-					// (NOTE: This is rough...Facebook can only be gauranteed
-					// to give us the year.)
-					// (NOTE: Skip this for now. Getting a user's birthday from
-					// Facebook requires app review.)
-					age := 18
-					/*if str, ok := res["birthday"].(string); ok {
-						slashIndex := strings.Index(str, "/")
-						if slashIndex > -1 {
-							str = str[(slashIndex + 1):]
-						}
+			success.Error = "Failed to create new User. Is the email address already registered?"
+		}
+	} else {
+		// (NOTE: The User can't log in until they have verified their email
+		// address, so no Session is created for them yet. See
+		// "POST /register/verify".)
+		data.VerificationSent = true
+	}
 
-						birthdayNumber, _ := strconv.Atoi(str)
-						age = (time.Now().Year() - birthdayNumber)
-					}*/
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
 
-					// Figure out what this User's ID will be
-					c := gDatabase.db.DB(dbDB).C("users")
-					count, _ := c.Count()
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
 
-					// Get name
-					name := ""
-					if str, ok := res["name"].(string); ok {
-						name = str
-					}
+// EndpointPOSTRegisterVerify handles the "POST /register/verify" API endpoint.
+func EndpointPOSTRegisterVerify(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
 
-					// Get profile picture URL
-					profilePictures := []string{}
-					if pictureObject, ok := res["picture"].(map[string]interface{}); ok {
-						if dataObject, ok := pictureObject["data"].(map[string]interface{}); ok {
-							if str, ok := dataObject["url"].(string); ok {
-								profilePictures = append(profilePictures, str)
-							}
-						}
-					}
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Token string `json:"token,omitempty"`
+	}
 
-					// Create the new User object
-					user := User{
-						ID:            count,
-						Name:          name,
-						Age:           age,
-						Interests:     map[string]int{},
-						Tags:          []string{},
-						Bio:           "",
-						Images:        profilePictures,
-						Matches:       []Match{},
-						Latitude:      0,
-						Longitude:     0,
-						LastActive:    time.Now().String(),
-						ShareLocation: true,
-					}
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
 
-					// Insert the User into the database
-					c.Insert(user)
-
-					// Insert the Facebook link for the user into the database
-					c = gDatabase.db.DB(dbDB).C("fb_links")
-					c.Insert(bson.M{"user_id": user.ID, "fb_user_id": fbUserID, "fb_access_token": r.FormValue("fb_access_token")})
-
-					// Create the new Session for the user and return their new API
-					// access token
-					session, _ := gSessionCache.CreateSession(user.ID)
-					data.Token = session.Token
-				} else { // Otherwise, if the User is linked in our database
-					// Update the Facebook Link's access token in the database
-					// (NOTE: We are assuming, at this point, potentially
-					// dangerously, that the User definitely has a valid Facebook
-					// link with the provided Facebook User ID in the database.)
-					c := gDatabase.db.DB(dbDB).C("fb_links")
-					query := bson.M{"fb_user_id": fbUserID}
-					change := bson.M{"$set": bson.M{"fb_access_token": r.FormValue("fb_access_token")}}
-					_ = c.Update(query, change)
-
-					// Create the new Session for the user and return their new API
-					// access token
-					session, _ := gSessionCache.CreateSession(m["user_id"].(int))
-					data.Token = session.Token
-				}
-			}
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.FormValue("email_token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'email_token' paramater is required."
+	} else if userID, err := VerifyEmail(r.FormValue("email_token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid or expired `email_token` provided to API call."
+	} else {
+		// Create the new Session for the user and return their new API access
+		// token (NOTE: Following the emailed link proves that they own the
+		// email address, so they are logged in straight away.)
+		session, _ := gSessionCache.CreateSession(userID)
+		data.Token = session.Token
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPOSTLoginPassword handles the "POST /login/password" API endpoint.
+func EndpointPOSTLoginPassword(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Token       string     `json:"token,omitempty"`
+		LockedUntil *time.Time `json:"locked_until,omitempty"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.FormValue("email") == "" || r.FormValue("password") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'email' and 'password' paramaters are required."
+	} else if userID, lockedUntil, err := LoginWithPassword(r.FormValue("email"), r.FormValue("password")); err != nil {
+		success.Success = false
+		if !lockedUntil.IsZero() {
+			success.Error = "Too many failed login attempts. Please try again later."
+			data.LockedUntil = &lockedUntil
+		} else if err == errEmailNotVerified {
+			success.Error = "Email address has not been verified yet. A new verification link has been sent to it."
+		} else if err == errUserTooYoung {
+			success.Error = "Users must be at least " + strconv.Itoa(gMinimumAge) + " years old to use AKTVE."
+		} else {
+			success.Error = "Invalid email address or password provided to API call."
 		}
+	} else {
+		// Create the new Session for the user and return their new API access
+		// token
+		session, _ := gSessionCache.CreateSession(userID)
+		data.Token = session.Token
 	}
 
 	// Combine the success and data structs so that they can be returned
@@ -190,6 +307,75 @@ func EndpointPOSTLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// EndpointPOSTPasswordReset handles the "POST /password/reset" API endpoint.
+func EndpointPOSTPasswordReset(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if !strings.Contains(r.FormValue("email"), "@") {
+		success.Success = false
+		success.Error = "Invalid API call. 'email' paramater must be a valid email address."
+	} else if err := SendPasswordReset(r.FormValue("email")); err != nil {
+		success.Success = false
+		success.Error = "Failed to send password reset link."
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPOSTPasswordResetConfirm handles the "POST /password/reset/confirm" API endpoint.
+func EndpointPOSTPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if r.FormValue("email_token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'email_token' paramater is required."
+	} else if err := ValidatePassword(r.FormValue("password")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'password' paramater must be between 8 and 72 characters long."
+	} else if err := ResetPassword(r.FormValue("email_token"), r.FormValue("password")); err != nil {
+		success.Success = false
+		success.Error = "Invalid or expired `email_token` provided to API call."
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
 // EndpointGETMeSettings handles the "GET /me/settings" API endpoint.
 func EndpointGETMeSettings(w http.ResponseWriter, r *http.Request) {
 	// Write the HTTP header for the response
@@ -442,6 +628,57 @@ func EndpointGETMe(w http.ResponseWriter, r *http.Request) {
 		success.Error = "Invalid API call. 'token' paramater must be a valid token."
 	} else {
 		data, _, _ = gUserCache.GetUser(userID)
+		data.SignImages(userID)
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointGETMeOnboarding handles the "GET /me/onboarding" API endpoint.
+func EndpointGETMeOnboarding(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Status  string   `json:"status"`
+		Missing []string `json:"missing"` // Everything that the User still has to do, in order
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else {
+		// Work out what the User has left to do, and keep their stored status
+		// in sync with it while we're at it
+		_, userCacheIndex, _ := gUserCache.GetUser(userID)
+		data.Status, data.Missing = gUserCache.Users[userCacheIndex].CheckOnboarding()
+		if data.Status != gUserCache.Users[userCacheIndex].Onboarding {
+			gUserCache.Users[userCacheIndex].Onboarding = data.Status
+			gUserCache.Users[userCacheIndex].Push()
+		}
 	}
 
 	// Combine the success and data structs so that they can be returned
@@ -491,9 +728,18 @@ func EndpointPUTMe(w http.ResponseWriter, r *http.Request) {
 			for _, value := range values {
 				if key == "name" {
 					gUserCache.Users[userCacheIndex].Name = value
-				} else if key == "age" {
-					if num, err := strconv.Atoi(value); err == nil {
-						gUserCache.Users[userCacheIndex].Age = num
+				} else if key == "birthdate" {
+					// (NOTE: A User's age is calculated from their birthdate,
+					// so "age" is no longer accepted here.)
+					if birthdate, err := ParseBirthdate(value); err != nil {
+						success.Success = false
+						success.Error = "Invalid API call. 'birthdate' paramater must be a valid date in the YYYY-MM-DD format."
+					} else if err := gUserCache.Users[userCacheIndex].SetBirthdate(birthdate, false); err == errUserTooYoung {
+						success.Success = false
+						success.Error = "Users must be at least " + strconv.Itoa(gMinimumAge) + " years old to use AKTVE."
+					} else if err != nil {
+						success.Success = false
+						success.Error = "Invalid API call. A birthdate verified by an identity provider cannot be changed."
 					}
 				} else if key == "interests" {
 					gUserCache.Users[userCacheIndex].Interests = map[string]int{}
@@ -504,8 +750,9 @@ func EndpointPUTMe(w http.ResponseWriter, r *http.Request) {
 				} else if key == "bio" {
 					gUserCache.Users[userCacheIndex].Bio = value
 				} else if key == "images" {
-					gUserCache.Users[userCacheIndex].Images = []string{}
-					_ = json.Unmarshal([]byte(value), &gUserCache.Users[userCacheIndex].Images)
+					images := []string{}
+					_ = json.Unmarshal([]byte(value), &images)
+					gUserCache.Users[userCacheIndex].SetImages(images)
 				} else if key == "latitude" {
 					if num, err := strconv.ParseFloat(value, 32); err == nil {
 						gUserCache.Users[userCacheIndex].Latitude = float32(num)
@@ -521,6 +768,7 @@ func EndpointPUTMe(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Push the updated local object into the database
+		gUserCache.Users[userCacheIndex].UpdateOnboarding()
 		gUserCache.Users[userCacheIndex].Push()
 	}
 
@@ -535,20 +783,23 @@ func EndpointPUTMe(w http.ResponseWriter, r *http.Request) {
 
 // EndpointDELETEMe handles the "DELETE /me" API endpoint.
 func EndpointDELETEMe(w http.ResponseWriter, r *http.Request) {
-	// Retrieve the variables from the endpoint
-	vars := mux.Vars(r)
-
 	// Write the HTTP header for the response
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 
 	// Create the actual data response structs of the API call
+	type GenericData struct {
+		DeletionDate time.Time `json:"deletion_date"`
+	}
+
 	type ReturnData struct {
 		Success Success
+		Data    GenericData
 	}
 
 	// Create the response structs
 	var success = Success{Success: true, Error: ""}
+	var data GenericData
 	var returnData ReturnData
 
 	// Process the API call
@@ -559,11 +810,233 @@ func EndpointDELETEMe(w http.ResponseWriter, r *http.Request) {
 		success.Success = false
 		success.Error = "Invalid API call. 'token' paramater must be a valid token."
 	} else {
-		var _ = vars
-
-		// Delete the User from the local cache and the database (WARNING: This
-		// is as final as it gets. The acount will be gone after this!)
-		gUserCache.DeleteUser(userID)
+		// Schedule the User for deletion from the local cache and the database
+		// (WARNING: Once the grace period is over, this is as final as it
+		// gets. The acount will be gone after this!)
+		if data.DeletionDate, err = gUserCache.ScheduleDeletion(userID); err != nil {
+			success.Success = false
+			success.Error = "Failed to schedule account for deletion."
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointDELETEMeDeletion handles the "DELETE /me/deletion" API endpoint.
+func EndpointDELETEMeDeletion(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else {
+		// Cancel the User's scheduled deletion
+		if err := gUserCache.CancelDeletion(userID); err != nil {
+			success.Success = false
+			success.Error = "Account is not scheduled for deletion."
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointGETMeExport handles the "GET /me/export" API endpoint.
+func EndpointGETMeExport(w http.ResponseWriter, r *http.Request) {
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else {
+		user, _, _ := gUserCache.GetUser(userID)
+
+		// Write the HTTP header for the response
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"aktve-export-"+strconv.Itoa(user.ID)+".zip\"")
+		w.WriteHeader(http.StatusOK)
+
+		// Write the User's export archive
+		// (NOTE: The header has already been sent at this point, so all we
+		// can do on failure is log it.)
+		if err := user.Export(w); err != nil {
+			RequestLogger(r).Error("There was an issue exporting the data of a User", "user_id", user.ID, "error", err)
+		}
+
+		return
+	}
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointGETMeIdentities handles the "GET /me/identities" API endpoint.
+func EndpointGETMeIdentities(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Identities []IdentityLink `json:"identities"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if data.Identities, err = GetIdentityLinks(userID); err != nil {
+		success.Success = false
+		success.Error = "Failed to retrieve linked identities."
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPOSTMeIdentities handles the "POST /me/identities" API endpoint.
+func EndpointPOSTMeIdentities(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if provider, err := GetIdentityProvider(r.FormValue("provider")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'provider' paramater must be a supported identity provider."
+	} else if r.ParseForm() != nil {
+		success.Success = false
+		success.Error = "Invalid API call. Could not parse form data."
+	} else if identity, err := provider.Authenticate(r.Form); err != nil {
+		success.Success = false
+		success.Error = "Invalid credentials provided to API call. Could not identify User with '" + r.FormValue("provider") + "'."
+	} else if err := LinkIdentity(userID, identity); err != nil {
+		success.Success = false
+		success.Error = "Failed to link identity. Is it already linked to a User?"
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointDELETEMeIdentitiesProvider handles the
+// "DELETE /me/identities/{provider}" API endpoint.
+func EndpointDELETEMeIdentitiesProvider(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the variables from the endpoint
+	vars := mux.Vars(r)
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if err := UnlinkIdentity(userID, vars["provider"]); err == errIdentityNotLinked {
+		success.Success = false
+		success.Error = "Invalid `provider` provided to API call. No identity from it is linked."
+	} else if err != nil {
+		success.Success = false
+		success.Error = "Failed to unlink identity. (NOTE: A User's last identity cannot be unlinked.)"
 	}
 
 	// Combine the success and data structs so that they can be returned
@@ -959,22 +1432,26 @@ func EndpointGETMeMatchesIDMessagesAfterID(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-// EndpointPUTMeImagesID handles the "PUT /me/images/{image_id}" API endpoint.
-func EndpointPUTMeImagesID(w http.ResponseWriter, r *http.Request) {
-	// Retrieve the variables from the endpoint
-	vars := mux.Vars(r)
-
+// EndpointGETMeLikesReceived handles the "GET /me/likes/received" API endpoint.
+func EndpointGETMeLikesReceived(w http.ResponseWriter, r *http.Request) {
 	// Write the HTTP header for the response
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 
 	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Likes      []Like     `json:"likes"`
+		Pagination Pagination `json:"pagination"`
+	}
+
 	type ReturnData struct {
 		Success Success
+		Data    GenericData
 	}
 
 	// Create the response structs
 	var success = Success{Success: true, Error: ""}
+	var data GenericData
 	var returnData ReturnData
 
 	// Process the API call
@@ -985,51 +1462,19 @@ func EndpointPUTMeImagesID(w http.ResponseWriter, r *http.Request) {
 		success.Success = false
 		success.Error = "Invalid API call. 'token' paramater must be a valid token."
 	} else {
-		_, userCacheIndex, _ := gUserCache.GetUser(userID)
-
-		// Create a new MD5 hasher
-		hasher := md5.New()
+		user, _, _ := gUserCache.GetUser(userID)
 
-		// Retrieve the body content from the HTTP request
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
+		// Retrieve the requested page of the Likes the User has received
+		data.Pagination = NewPagination(r)
+		if data.Likes, data.Pagination.Total, err = user.GetReceivedLikes(data.Pagination.Offset, data.Pagination.Limit); err != nil {
 			success.Success = false
-			success.Error = "Failed to proccess HTTP body."
-		}
-
-		// Calculate hash of body content
-		hash := hasher.Sum(body)
-
-		// Create new File struct so we can put it in the database
-		entry := File{
-			ID:     bson.NewObjectId(),
-			Data:   body,
-			Type:   "image/jpeg",
-			Length: len(body),
-			MD5:    hash,
-		}
-
-		// Push the entry into the database
-		c := gDatabase.db.DB(dbDB).C("files")
-		c.Insert(entry)
-
-		// Add the new URL to the User's object and push it to the database
-		// (TODO: You should really delete the previous image from the database
-		// it is getting overwritten.)
-		imageIndex, _ := strconv.Atoi(vars["image_id"])
-		imageURL := (gAPIURL + "/file/" + entry.ID.Hex())
-
-		if (len(gUserCache.Users[userCacheIndex].Images) - 1) < imageIndex {
-			gUserCache.Users[userCacheIndex].Images = append(gUserCache.Users[userCacheIndex].Images, imageURL)
-		} else {
-			gUserCache.Users[userCacheIndex].Images[imageIndex] = imageURL
+			success.Error = "Failed to retrieve received likes."
 		}
-
-		gUserCache.Users[userCacheIndex].Push()
 	}
 
 	// Combine the success and data structs so that they can be returned
 	returnData.Success = success
+	returnData.Data = data
 
 	// Respond with the JSON-encoded return data
 	if err := json.NewEncoder(w).Encode(returnData); err != nil {
@@ -1037,18 +1482,16 @@ func EndpointPUTMeImagesID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// EndpointGETUsersID handles the "GET /user/{user_id}" API endpoint.
-func EndpointGETUsersID(w http.ResponseWriter, r *http.Request) {
-	// Retrieve the variables from the endpoint
-	vars := mux.Vars(r)
-
+// EndpointGETMeLikesSent handles the "GET /me/likes/sent" API endpoint.
+func EndpointGETMeLikesSent(w http.ResponseWriter, r *http.Request) {
 	// Write the HTTP header for the response
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 
 	// Create the actual data response structs of the API call
 	type GenericData struct {
-		User User `json:"user,omitempty"`
+		Likes      []Like     `json:"likes"`
+		Pagination Pagination `json:"pagination"`
 	}
 
 	type ReturnData struct {
@@ -1065,19 +1508,17 @@ func EndpointGETUsersID(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("token") == "" {
 		success.Success = false
 		success.Error = "Invalid API call. 'token' paramater is required."
-	} else if _, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
 		success.Success = false
 		success.Error = "Invalid API call. 'token' paramater must be a valid token."
 	} else {
-		if id, err := strconv.Atoi(vars["user_id"]); err == nil {
-			// Attempt to get User from the database
-			if data.User, _, err = gUserCache.GetUser(id); err != nil {
-				success.Success = false
-				success.Error = "Invalid `user_id` provided to API call. User does not exist."
-			}
-		} else {
+		user, _, _ := gUserCache.GetUser(userID)
+
+		// Retrieve the requested page of the Likes the User has given
+		data.Pagination = NewPagination(r)
+		if data.Likes, data.Pagination.Total, err = user.GetSentLikes(data.Pagination.Offset, data.Pagination.Limit); err != nil {
 			success.Success = false
-			success.Error = "Internal API error. Was the provided `match_id` a valid number?"
+			success.Error = "Failed to retrieve sent likes."
 		}
 	}
 
@@ -1091,8 +1532,9 @@ func EndpointGETUsersID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// EndpointPUTUsersIDFeeling handles the "PUT /user/{user_id}/feeling" API endpoint.
-func EndpointPUTUsersIDFeeling(w http.ResponseWriter, r *http.Request) {
+// EndpointDELETEMeLikesSentID handles the "DELETE /me/likes/sent/{user_id}"
+// API endpoint.
+func EndpointDELETEMeLikesSentID(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the variables from the endpoint
 	vars := mux.Vars(r)
 
@@ -1116,58 +1558,17 @@ func EndpointPUTUsersIDFeeling(w http.ResponseWriter, r *http.Request) {
 	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
 		success.Success = false
 		success.Error = "Invalid API call. 'token' paramater must be a valid token."
-	} else if r.FormValue("feeling") != "like" && r.FormValue("feeling") != "dislike" {
-		success.Success = false
-		success.Error = "Invalid API call. 'feeling' paramater must either be 'like' or 'dislike'."
 	} else {
 		if otherUserID, err := strconv.Atoi(vars["user_id"]); err == nil {
+			// Withdraw the User's Like of the other User
 			user, _, _ := gUserCache.GetUser(userID)
-			if otherUser, _, err := gUserCache.GetUser(otherUserID); err == nil {
-				if r.FormValue("feeling") == "like" {
-					// Switch to the "likes" database
-					c := gDatabase.db.DB(dbDB).C("likes")
-
-					// Get the latest Like from the database so that we know
-					// what the ID should be for this Like
-					var count int
-					var err error
-
-					if count, err = c.Count(); err != nil {
-						success.Success = false
-						success.Error = "Failed to add like."
-					}
-
-					// Create the new Like
-					like := Like{
-						ID:      count,
-						LikerID: user.ID,
-						LikeeID: otherUser.ID,
-					}
-
-					// Push the new Like up to the database
-					if err = c.Insert(&like); err != nil {
-						success.Success = false
-						success.Error = "Failed to add like."
-					}
-
-					// (TODO: Add this like to any local caches.)
-				} else if r.FormValue("feeling") != "dislike" {
-					// Remove any likes for the specified User by the User
-					c := gDatabase.db.DB(dbDB).C("likes")
-					if err := c.Remove(bson.M{"liker_id": userID, "likee_id": otherUserID}); err != nil {
-						success.Success = false
-						success.Error = "Failed to remove any specified likes."
-					}
-
-					// (TODO: Remove this like from any local caches.)
-				}
-			} else {
+			if err := user.WithdrawLike(otherUserID); err != nil {
 				success.Success = false
-				success.Error = "Invalid `user_id` provided to API call. User does not exist."
+				success.Error = "Invalid `user_id` provided to API call. User is not currently liked."
 			}
 		} else {
 			success.Success = false
-			success.Error = "Internal API error. Was the provided `match_id` a valid number?"
+			success.Error = "Internal API error. Was the provided `user_id` a valid number?"
 		}
 	}
 
@@ -1180,8 +1581,8 @@ func EndpointPUTUsersIDFeeling(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// EndpointGETPotentials handles the "GET /user/{user_id}" API endpoint.
-func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
+// EndpointPUTMeImagesID handles the "PUT /me/images/{image_id}" API endpoint.
+func EndpointPUTMeImagesID(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the variables from the endpoint
 	vars := mux.Vars(r)
 
@@ -1190,18 +1591,12 @@ func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	// Create the actual data response structs of the API call
-	type GenericData struct {
-		PotentialUserIDs []int `json:"potential_user_ids,omitempty"`
-	}
-
 	type ReturnData struct {
 		Success Success
-		Data    GenericData
 	}
 
 	// Create the response structs
 	var success = Success{Success: true, Error: ""}
-	var data GenericData
 	var returnData ReturnData
 
 	// Process the API call
@@ -1212,12 +1607,554 @@ func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
 		success.Success = false
 		success.Error = "Invalid API call. 'token' paramater must be a valid token."
 	} else {
-		var _ = vars
-		var users []User
+		_, userCacheIndex, _ := gUserCache.GetUser(userID)
+
+		// Figure out whether the image is being added or is overwriting an
+		// existing one
+		imageIndex, _ := strconv.Atoi(vars["image_id"])
+		appending := (imageIndex < 0 || (len(gUserCache.Users[userCacheIndex].Images)-1) < imageIndex)
+
+		// Retrieve the body content from the HTTP request (up to the maximum
+		// image upload size)
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(gMaxImageUploadSize)))
+		if appending && len(gUserCache.Users[userCacheIndex].Images) >= gMaxUserImages {
+			success.Success = false
+			success.Error = "Users may not have more than " + strconv.Itoa(gMaxUserImages) + " images."
+		} else if err != nil {
+			success.Success = false
+			success.Error = "Failed to proccess HTTP body. Images must be at most " + strconv.Itoa(gMaxImageUploadSize) + " bytes."
+		} else if entry, err := StoreImage(body, userID); err != nil {
+			// (NOTE: StoreImage validates, decodes, strips the metadata from
+			// and re-encodes the image, and then stores each of its
+			// renditions.)
+			success.Success = false
+			success.Error = "Invalid image provided to API call. Images must be JPEG, PNG, WebP or GIF files."
+		} else {
+			// Add the new URL to the User's images (releasing the previous
+			// image in the slot, if it is being overwritten) and push it to the
+			// database
+			imageURL := FileURL(entry.ID)
+
+			images := append([]string{}, gUserCache.Users[userCacheIndex].Images...)
+			if appending {
+				images = append(images, imageURL)
+			} else {
+				images[imageIndex] = imageURL
+			}
+
+			if err := gUserCache.Users[userCacheIndex].SetImages(images); err != nil {
+				success.Success = false
+				success.Error = "Failed to update images."
+			}
+
+			gUserCache.Users[userCacheIndex].UpdateOnboarding()
+			gUserCache.Users[userCacheIndex].Push()
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPUTMeImages handles the "PUT /me/images" API endpoint.
+func EndpointPUTMeImages(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Images []string `json:"images"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if r.FormValue("order") == "" && r.FormValue("primary") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. Either the 'order' or 'primary' paramater must be provided."
+	} else {
+		_, userCacheIndex, _ := gUserCache.GetUser(userID)
+
+		// Parse the requested order of file IDs and the primary file ID
+		var order []bson.ObjectId
+		var primary bson.ObjectId
+
+		orderIDs := []string{}
+		if r.FormValue("order") != "" {
+			if err := json.Unmarshal([]byte(r.FormValue("order")), &orderIDs); err != nil {
+				success.Success = false
+				success.Error = "Invalid API call. 'order' paramater must be a JSON array of file IDs."
+			}
+		}
+		for _, element := range orderIDs {
+			if !bson.IsObjectIdHex(element) {
+				success.Success = false
+				success.Error = "Invalid API call. 'order' paramater must be a JSON array of file IDs."
+				break
+			}
+
+			order = append(order, bson.ObjectIdHex(element))
+		}
+
+		if r.FormValue("primary") != "" {
+			if bson.IsObjectIdHex(r.FormValue("primary")) {
+				primary = bson.ObjectIdHex(r.FormValue("primary"))
+			} else {
+				success.Success = false
+				success.Error = "Invalid API call. 'primary' paramater must be a file ID."
+			}
+		}
+
+		// Rearrange the User's images and push them to the database
+		if success.Success {
+			if err := gUserCache.Users[userCacheIndex].ReorderImages(order, primary); err != nil {
+				success.Success = false
+				success.Error = "Invalid images provided to API call. The " + err.Error() + "."
+			} else {
+				gUserCache.Users[userCacheIndex].Push()
+			}
+		}
+
+		data.Images = []string{}
+		for _, element := range gUserCache.Users[userCacheIndex].Images {
+			data.Images = append(data.Images, SignFileURL(element, userID))
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointDELETEMeImagesID handles the "DELETE /me/images/{image_id}" API
+// endpoint.
+func EndpointDELETEMeImagesID(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the variables from the endpoint
+	vars := mux.Vars(r)
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		Images []string `json:"images"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else {
+		_, userCacheIndex, _ := gUserCache.GetUser(userID)
+
+		if imageIndex, err := strconv.Atoi(vars["image_id"]); err != nil {
+			success.Success = false
+			success.Error = "Internal API error. Was the provided `image_id` a valid number?"
+		} else if imageIndex < 0 || (len(gUserCache.Users[userCacheIndex].Images)-1) < imageIndex {
+			success.Success = false
+			success.Error = "Invalid `image_id` provided to API call. Image does not exist for User."
+		} else {
+			// Remove the image from the User's images (moving the following
+			// images up to fill the gap) and push it to the database
+			images := append([]string{}, gUserCache.Users[userCacheIndex].Images[:imageIndex]...)
+			images = append(images, gUserCache.Users[userCacheIndex].Images[(imageIndex+1):]...)
+
+			if err := gUserCache.Users[userCacheIndex].SetImages(images); err != nil {
+				success.Success = false
+				success.Error = "Failed to update images."
+			}
+
+			gUserCache.Users[userCacheIndex].UpdateOnboarding()
+			gUserCache.Users[userCacheIndex].Push()
+		}
+
+		data.Images = []string{}
+		for _, element := range gUserCache.Users[userCacheIndex].Images {
+			data.Images = append(data.Images, SignFileURL(element, userID))
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointGETUsersID handles the "GET /user/{user_id}" API endpoint.
+func EndpointGETUsersID(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the variables from the endpoint
+	vars := mux.Vars(r)
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		User         User `json:"user,omitempty"`
+		SuperLikedMe bool `json:"super_liked_me,omitempty"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else {
+		if id, err := strconv.Atoi(vars["user_id"]); err == nil {
+			// Attempt to get User from the database
+			if data.User, _, err = gUserCache.GetUser(id); err != nil {
+				success.Success = false
+				success.Error = "Invalid `user_id` provided to API call. User does not exist."
+			} else {
+				// Only show other Users the images that have been approved by
+				// moderation, and sign them for the app User to view
+				if id != userID {
+					if err := data.User.HideUnapprovedImages(); err != nil {
+						RequestLogger(r).Error("There was an issue retrieving the moderation states of a User's images, so all of them were hidden", "user_id", id, "error", err)
+					}
+				}
+
+				// Only show other Users the User's age, not their birthdate
+				if id != userID {
+					data.User.Birthdate = nil
+				}
+				data.User.SignImages(userID)
+
+				// Flag the User if they have super-liked the app User
+				user, _, _ := gUserCache.GetUser(userID)
+				data.SuperLikedMe = user.IsSuperLikedBy(id)
+			}
+		} else {
+			success.Success = false
+			success.Error = "Internal API error. Was the provided `match_id` a valid number?"
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPUTUsersIDFeeling handles the "PUT /user/{user_id}/feeling" API endpoint.
+func EndpointPUTUsersIDFeeling(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the variables from the endpoint
+	vars := mux.Vars(r)
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		LikesRemaining      int        `json:"likes_remaining"`
+		SuperLikesRemaining int        `json:"superlikes_remaining"`
+		LikesResetAt        time.Time  `json:"likes_reset_at"`
+		ThrottledUntil      *time.Time `json:"throttled_until,omitempty"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if r.FormValue("feeling") != "like" && r.FormValue("feeling") != "superlike" && r.FormValue("feeling") != "dislike" {
+		success.Success = false
+		success.Error = "Invalid API call. 'feeling' paramater must either be 'like', 'superlike' or 'dislike'."
+	} else if throttledUntil, err := gSwipeTracker.RecordSwipe(userID); err != nil {
+		success.Success = false
+		success.Error = "Too many swipes in a short period of time. Please try again later."
+		data.ThrottledUntil = &throttledUntil
+	} else {
+		if otherUserID, err := strconv.Atoi(vars["user_id"]); err == nil {
+			user, _, _ := gUserCache.GetUser(userID)
+			if otherUserID == userID {
+				success.Success = false
+				success.Error = "Invalid `user_id` provided to API call. Users can't like themselves."
+			} else if otherUser, _, err := gUserCache.GetUser(otherUserID); err == nil {
+				// Figure out how much of the User's daily like quotas have been
+				// used up so far
+				now := time.Now()
+				likesGiven, superLikesGiven, err := GetLikesGiven(user.ID, now)
+
+				if err != nil {
+					success.Success = false
+					success.Error = "Failed to retrieve daily like quota."
+				} else {
+					feeling := r.FormValue("feeling")
+
+					if feeling == "like" || feeling == "superlike" {
+						db := gDatabase.Copy()
+						defer db.Close()
+
+						// Switch to the "likes" database
+						c := db.DB(dbDB).C("likes")
+
+						// See if the User already likes the other User
+						var existing Like
+						query := bson.M{"liker_id": user.ID, "likee_id": otherUser.ID}
+						existingErr := c.Find(query).One(&existing)
+
+						if existingErr != nil && existingErr != mgo.ErrNotFound {
+							success.Success = false
+							success.Error = "Failed to retrieve existing like."
+						} else if existingErr == nil && (existing.IsSuperLike() || feeling == "like") {
+							// (NOTE: Liking a User that is already liked is a
+							// no-op, and does not count against the daily like
+							// quotas.)
+						} else if feeling == "like" && likesGiven >= gDailyLikeQuota {
+							success.Success = false
+							success.Error = "Daily like quota reached. Please try again after it resets."
+						} else if feeling == "superlike" && superLikesGiven >= gDailySuperLikeQuota {
+							success.Success = false
+							success.Error = "Daily super-like quota reached. Please try again after it resets."
+						} else {
+							// Claim this Like from the daily like quota before
+							// storing it (NOTE: The check above is only a
+							// shortcut, as other Likes may have been given since.)
+							quota := gDailyLikeQuota
+							if feeling == "superlike" {
+								quota = gDailySuperLikeQuota
+							}
+							claimedLikes, claimedSuperLikes, err := ClaimLikeGiven(user.ID, feeling, quota, now)
+
+							if err == errLikeQuotaReached && feeling == "superlike" {
+								success.Success = false
+								success.Error = "Daily super-like quota reached. Please try again after it resets."
+							} else if err == errLikeQuotaReached {
+								success.Success = false
+								success.Error = "Daily like quota reached. Please try again after it resets."
+							} else if err != nil {
+								success.Success = false
+								success.Error = "Failed to update daily like quota."
+							} else {
+								likesGiven, superLikesGiven = claimedLikes, claimedSuperLikes
+								given := false
+
+								if existingErr == nil {
+									// Upgrade the existing Like to a super-like
+									// (NOTE: The Like keeps the date it was first
+									// given.)
+									change := bson.M{"$set": bson.M{"feeling": "superlike", "superliked_at": now}}
+									if err := c.Update(query, change); err != nil {
+										success.Success = false
+										success.Error = "Failed to add like."
+									} else {
+										given = true
+									}
+								} else {
+									// Allocate the ID for this Like (NOTE: This only
+									// happens once the Like is known to be new and
+									// within the quota, but the ID is used up even
+									// if the same Like is stored by another request
+									// first or fails to be stored. Like IDs only
+									// need to be unique, so gaps are fine. It can't
+									// be allocated after the Like is stored, as
+									// "id" is uniquely indexed.)
+									id, idErr := NextID("likes")
+
+									// Create the new Like
+									like := Like{
+										ID:      id,
+										LikerID: user.ID,
+										LikeeID: otherUser.ID,
+										Feeling: feeling,
+										Date:    now,
+									}
+
+									// Push the new Like up to the database (only if
+									// one does not already exist for this pair of
+									// Users, in case the same like was sent twice
+									// at once)
+									change := bson.M{"$setOnInsert": like}
+									if idErr != nil {
+										success.Success = false
+										success.Error = "Failed to add like."
+									} else if info, err := c.Upsert(query, change); err != nil {
+										success.Success = false
+										success.Error = "Failed to add like."
+									} else if info.UpsertedId != nil {
+										given = true
+										if otherUser.CurrentlyLikes(user.ID) {
+											gMatches.Inc()
+										}
+									}
+								}
+
+								// Give the claimed Like back if it wasn't given
+								// after all
+								if given {
+									gLikes.WithLabelValues(feeling).Inc()
+								} else {
+									if feeling == "superlike" {
+										superLikesGiven--
+									} else {
+										likesGiven--
+									}
+									if err := ReturnLikeGiven(user.ID, feeling, now); err != nil {
+										RequestLogger(r).Error("Failed to return like to quota", "error", err)
+									}
+								}
+							}
+						}
+
+						// (TODO: Add this like to any local caches.)
+					} else if feeling == "dislike" {
+						db := gDatabase.Copy()
+						defer db.Close()
+
+						// Remove any likes for the specified User by the User
+						c := db.DB(dbDB).C("likes")
+						if _, err := c.RemoveAll(bson.M{"liker_id": userID, "likee_id": otherUserID}); err != nil {
+							success.Success = false
+							success.Error = "Failed to remove any specified likes."
+						}
+
+						// (TODO: Remove this like from any local caches.)
+					}
+
+					// Report how much of the daily like quotas are left
+					data.LikesRemaining = LikesRemaining(gDailyLikeQuota, likesGiven)
+					data.SuperLikesRemaining = LikesRemaining(gDailySuperLikeQuota, superLikesGiven)
+					data.LikesResetAt = LikeQuotaReset(now)
+				}
+			} else {
+				success.Success = false
+				success.Error = "Invalid `user_id` provided to API call. User does not exist."
+			}
+		} else {
+			success.Success = false
+			success.Error = "Internal API error. Was the provided `user_id` a valid number?"
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointGETPotentials handles the "GET /user/{user_id}" API endpoint.
+func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the variables from the endpoint
+	vars := mux.Vars(r)
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type GenericData struct {
+		PotentialUserIDs []int `json:"potential_user_ids,omitempty"`
+		SuperLikerIDs    []int `json:"super_liker_ids,omitempty"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if user, _, _ := gUserCache.GetUser(userID); !user.IsOnboarded(OnboardingLocationSet) {
+		success.Success = false
+		success.Error = "Users must finish setting up their profile (see 'GET /me/onboarding') before they can be matched with anyone."
+	} else {
+		var _ = vars
+		var users []User
 		user, userCacheIndex, _ := gUserCache.GetUser(userID)
 
+		db := gDatabase.Copy()
+		defer db.Close()
+
 		// Switch to the "users" collection
-		c := gDatabase.db.DB(dbDB).C("users")
+		c := db.DB(dbDB).C("users")
 
 		// Match users based on location, age, interests, and skill levels
 		// (TODO: This algorithm should be worked on and enhanced. It is not
@@ -1240,11 +2177,19 @@ func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
 			maxLongitude = (user.Longitude + distance)
 			minLongitude = (user.Longitude - distance)
 
-			// Calculate the maximum and minimum age
+			// Calculate the maximum and minimum age, and the range of
+			// birthdates that those ages cover today
+			// (NOTE: Matching on birthdates rather than stored ages means
+			// that Users move into the right age range on their birthdays.)
 			years := 10
 
 			maxAge := (user.Age + years)
 			minAge := (user.Age - years)
+			if minAge < gMinimumAge {
+				minAge = gMinimumAge
+			}
+
+			earliestBirthdate, latestBirthdate := BirthdateRange(minAge, maxAge, time.Now())
 
 			// Build up the query and find the potential Users
 			query := bson.M{}
@@ -1252,7 +2197,14 @@ func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
 			query["latitude"] = bson.M{"$lte": maxLatitude, "$gte": minLatitude}
 			query["longitude"] = bson.M{"$lte": maxLongitude, "$gte": minLongitude}
 
-			query["age"] = bson.M{"$lte": maxAge, "$gte": minAge}
+			query["birthdate"] = bson.M{"$lte": latestBirthdate, "$gt": earliestBirthdate}
+
+			// Only suggest Users who have finished setting up their profile,
+			// and whose accounts aren't about to be deleted (NOTE: Finishing
+			// onboarding requires a birthdate, so Users without one are asked
+			// for it rather than silently falling outside of every age range.)
+			query["onboarding"] = OnboardingActive
+			query["deletion_date"] = bson.M{"$exists": false}
 
 			if len(user.Interests) > 0 {
 				queryInterests := []bson.M{}
@@ -1262,19 +2214,38 @@ func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
 				query["$or"] = queryInterests
 			}
 
-			if err := c.Find(query).All(&users); err == nil {
+			done := TimeDatabase("users", "find")
+			err := c.Find(query).All(&users)
+			done()
+
+			if err == nil {
 				// Update the User's Matches so that we can check against them
 				// so that we don't return potential Users that have already
 				// been successfully matched
 				gUserCache.Users[userCacheIndex].PullMatches()
 
+				// Retrieve the Users that have super-liked the User so that they
+				// can be boosted to the top of the potential Users
+				superLikerIDs, _ := user.GetSuperLikerIDs()
+				superLiked := map[int]bool{}
+				for _, element := range superLikerIDs {
+					superLiked[element] = true
+				}
+
 				// Pack the potential User IDs into the output struct
+				otherIDs := []int{}
 				for _, element := range users {
 					// Filter out any Users that are already matched or liked, and filter out self
 					if element.ID != userID && !gUserCache.Users[userCacheIndex].IsMatchedWith(element.ID) && !gUserCache.Users[userCacheIndex].CurrentlyLikes(element.ID) {
-						data.PotentialUserIDs = append(data.PotentialUserIDs, element.ID)
+						if superLiked[element.ID] {
+							data.PotentialUserIDs = append(data.PotentialUserIDs, element.ID)
+							data.SuperLikerIDs = append(data.SuperLikerIDs, element.ID)
+						} else {
+							otherIDs = append(otherIDs, element.ID)
+						}
 					}
 				}
+				data.PotentialUserIDs = append(data.PotentialUserIDs, otherIDs...)
 			} else {
 				success.Success = false
 				success.Error = "Failed to find any users."
@@ -1292,28 +2263,200 @@ func EndpointGETPotentials(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// EndpointGETAdminModeration handles the "GET /admin/moderation" API
+// endpoint.
+func EndpointGETAdminModeration(w http.ResponseWriter, r *http.Request) {
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type PendingFile struct {
+		File
+		URL      string `json:"url"`
+		OwnerIDs []int  `json:"owner_ids"`
+	}
+
+	type GenericData struct {
+		Files      []PendingFile `json:"files"`
+		Pagination Pagination    `json:"pagination"`
+	}
+
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var data GenericData
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if user, _, _ := gUserCache.GetUser(userID); !user.Admin {
+		success.Success = false
+		success.Error = "Invalid API call. Only administrators may moderate images."
+	} else {
+		// Retrieve the requested page of images waiting to be moderated
+		var files []File
+
+		data.Files = []PendingFile{}
+		data.Pagination = NewPagination(r)
+		if files, data.Pagination.Total, err = GetPendingFiles(data.Pagination.Offset, data.Pagination.Limit); err != nil {
+			success.Success = false
+			success.Error = "Failed to retrieve images waiting to be moderated."
+		}
+
+		for _, file := range files {
+			data.Files = append(data.Files, PendingFile{file, SignFileURL(FileURL(file.ID), userID), file.OwnerIDs})
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+	returnData.Data = data
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointPUTAdminModerationID handles the "PUT /admin/moderation/{file_id}"
+// API endpoint.
+func EndpointPUTAdminModerationID(w http.ResponseWriter, r *http.Request) {
+	// Retrieve the variables from the endpoint
+	vars := mux.Vars(r)
+
+	// Write the HTTP header for the response
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	// Create the actual data response structs of the API call
+	type ReturnData struct {
+		Success Success
+	}
+
+	// Create the response structs
+	var success = Success{Success: true, Error: ""}
+	var returnData ReturnData
+
+	// Process the API call
+	if r.URL.Query().Get("token") == "" {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater is required."
+	} else if userID, err := gSessionCache.CheckSession(r.URL.Query().Get("token")); err != nil {
+		success.Success = false
+		success.Error = "Invalid API call. 'token' paramater must be a valid token."
+	} else if user, _, _ := gUserCache.GetUser(userID); !user.Admin {
+		success.Success = false
+		success.Error = "Invalid API call. Only administrators may moderate images."
+	} else if r.FormValue("decision") != "approved" && r.FormValue("decision") != "rejected" {
+		success.Success = false
+		success.Error = "Invalid API call. 'decision' paramater must either be 'approved' or 'rejected'."
+	} else if !bson.IsObjectIdHex(vars["file_id"]) {
+		success.Success = false
+		success.Error = "Internal API error. Was the provided `file_id` a valid file ID?"
+	} else {
+		// Record the moderator's decision on the image
+		if err := ModerateFile(bson.ObjectIdHex(vars["file_id"]), r.FormValue("decision"), r.FormValue("reason")); err != nil {
+			success.Success = false
+			success.Error = "Invalid `file_id` provided to API call. File does not exist."
+		}
+	}
+
+	// Combine the success and data structs so that they can be returned
+	returnData.Success = success
+
+	// Respond with the JSON-encoded return data
+	if err := json.NewEncoder(w).Encode(returnData); err != nil {
+		panic(err)
+	}
+}
+
 // EndpointGETFileID handles the "GET /file/{file_id}" API endpoint.
 func EndpointGETFileID(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the variables from the endpoint
 	vars := mux.Vars(r)
 
 	// Process the API call
-	{
+	if !bson.IsObjectIdHex(vars["file_id"]) {
+		http.Error(w, "Invalid `file_id` provided to API call.", http.StatusBadRequest)
+	} else if viewerID, expires, err := VerifyFileURL(bson.ObjectIdHex(vars["file_id"]), r.URL.Query()); err != nil {
+		http.Error(w, "Invalid or expired file URL provided to API call.", http.StatusForbidden)
+	} else if viewer, _, err := gUserCache.GetUser(viewerID); err != nil {
+		http.Error(w, "Invalid or expired file URL provided to API call.", http.StatusForbidden)
+	} else {
+		db := gDatabase.Copy()
+		defer db.Close()
+
 		// Switch to the "files" collection
-		c := gDatabase.db.DB(dbDB).C("files")
+		c := db.DB(dbDB).C("files")
 
 		// Find the file
 		var file File
-		if err := c.Find(bson.M{"_id": bson.ObjectIdHex(vars["file_id"])}).One(&file); err == nil {
-			// Write the HTTP header for the response
-			w.Header().Set("Content-Type", file.Type)
-			w.Header().Set("Content-Length", strconv.Itoa(file.Length))
-			w.WriteHeader(http.StatusOK)
-
-			// Write the file's data
-			w.Write(file.Data)
+		if err := c.FindId(bson.ObjectIdHex(vars["file_id"])).One(&file); err == mgo.ErrNotFound {
+			http.Error(w, "File does not exist.", http.StatusNotFound)
+		} else if err != nil {
+			RequestLogger(r).Error("There was an issue finding the file with the requested ID in the database", "file_id", vars["file_id"], "error", err)
+			http.Error(w, "Failed to retrieve file.", http.StatusInternalServerError)
+		} else if viewable, err := file.IsViewableBy(viewer); err != nil {
+			RequestLogger(r).Error("There was an issue checking the moderation state of the file with the requested ID", "file_id", vars["file_id"], "error", err)
+			http.Error(w, "Failed to retrieve file.", http.StatusInternalServerError)
+		} else if !viewable {
+			// (NOTE: The file's URL may have been signed before it was
+			// rejected, so this has to be checked every time.)
+			http.Error(w, "File does not exist.", http.StatusNotFound)
 		} else {
-			log.Printf("There was an issue finding the file with the requested ID (%s) in the database.", vars["file_id"])
+			// Switch to the requested rendition of the file (if there is one)
+			if size := r.URL.Query().Get("size"); size != "" {
+				if rendition, err := file.GetRendition(size); err == nil {
+					file = rendition
+				}
+			}
+
+			// Files never change once they are stored (a new upload always
+			// gets a new ID), so they can be cached until their URL expires,
+			// and validated against their content hash (NOTE: Only by the
+			// viewer the URL was signed for, not by shared caches.)
+			maxAge := int(time.Until(expires).Seconds())
+			w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge)+", immutable")
+			if file.SHA256 != "" {
+				w.Header().Set("ETag", "\""+file.SHA256+"\"")
+
+				// Skip retrieving the file's data entirely if the client
+				// already has it
+				if ETagMatches(r.Header.Get("If-None-Match"), file.SHA256) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+
+			// Retrieve the file's data and make sure it hasn't been corrupted
+			// before serving it
+			data, err := file.ReadData()
+			if err != nil {
+				RequestLogger(r).Error("There was an issue retrieving the file with the requested ID from the blob store", "file_id", file.ID.Hex(), "error", err)
+				http.Error(w, "Failed to retrieve file.", http.StatusInternalServerError)
+				return
+			}
+			if err := file.Verify(data); err != nil {
+				RequestLogger(r).Error("There was an issue verifying the integrity of the file with the requested ID", "file_id", file.ID.Hex(), "error", err)
+				http.Error(w, "Failed to retrieve file.", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("ETag", "\""+file.SHA256+"\"")
+
+			// Write the file's data (NOTE: ServeContent takes care of the
+			// Range, If-None-Match and If-Modified-Since headers for us.)
+			w.Header().Set("Content-Type", file.Type)
+			http.ServeContent(w, r, "", file.ID.Time(), bytes.NewReader(data))
 		}
 	}
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// UserExport is a model used to represent everything that is stored about a
+// User of AKTVE, so that it can be handed over to them on request.
+type UserExport struct {
+	ExportDate    time.Time `json:"export_date"`
+	User          User      `json:"user"`
+	Matches       []Match   `json:"matches"`
+	Messages      []Message `json:"messages"`
+	LikesSent     []Like    `json:"likes_sent"`
+	LikesReceived []Like    `json:"likes_received"`
+	Images        []string  `json:"images"` // The paths of the User's images within the export archive
+}
+
+// Export writes a ZIP archive containing everything that is stored about the
+// User (as JSON, along with their uploaded images) to the provided writer.
+func (o *User) Export(w io.Writer) error {
+	export := UserExport{
+		ExportDate:    time.Now(),
+		User:          *o,
+		Matches:       []Match{},
+		Messages:      []Message{},
+		LikesSent:     []Like{},
+		LikesReceived: []Like{},
+		Images:        []string{},
+	}
+
+	// Gather up the User's Matches, Messages and Likes
+	if err := o.PullMatches(); err != nil {
+		return err
+	}
+	export.Matches = o.Matches
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("messages")
+	if err := c.Find(bson.M{"participants": o.ID}).All(&export.Messages); err != nil {
+		return errors.New("export: failed to retrieve Messages")
+	}
+
+	c = db.DB(dbDB).C("likes")
+	if err := c.Find(bson.M{"liker_id": o.ID}).All(&export.LikesSent); err != nil {
+		return errors.New("export: failed to retrieve sent Likes")
+	}
+	if err := c.Find(bson.M{"likee_id": o.ID}).All(&export.LikesReceived); err != nil {
+		return errors.New("export: failed to retrieve received Likes")
+	}
+
+	archive := zip.NewWriter(w)
+
+	// Add each of the User's uploaded images to the archive
+	c = db.DB(dbDB).C("files")
+	for _, element := range o.Images {
+		fileID, ok := FileIDFromURL(element)
+		if !ok {
+			continue
+		}
+
+		var file File
+		if err := c.FindId(fileID).One(&file); err != nil {
+			continue
+		}
+
+		name := "images/" + file.ID.Hex()
+		if extensions, err := mime.ExtensionsByType(file.Type); err == nil && len(extensions) > 0 {
+			name += extensions[0]
+		}
+
+		data, err := file.ReadData()
+		if err != nil {
+			continue
+		}
+
+		entry, err := archive.Create(name)
+		if err != nil {
+			return errors.New("export: failed to add image to archive")
+		}
+		if _, err := entry.Write(data); err != nil {
+			return errors.New("export: failed to add image to archive")
+		}
+
+		export.Images = append(export.Images, name)
+	}
+
+	// Add the JSON representation of everything else to the archive
+	entry, err := archive.Create("data.json")
+	if err != nil {
+		return errors.New("export: failed to add data to archive")
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return errors.New("export: failed to add data to archive")
+	}
+
+	return archive.Close()
+}
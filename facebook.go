@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// FacebookProfile is a struct representing the parts of a Facebook User's
+// profile that AKTVE asks for.
+type FacebookProfile struct {
+	ID       string `json:"id"` // (NOTE: Facebook User IDs are strings, and may not fit in an int.)
+	Name     string `json:"name"`
+	Birthday string `json:"birthday"` // Either "MM/DD/YYYY", "MM/DD" or "YYYY", depending on what the User shares
+	Picture  struct {
+		Data struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	} `json:"picture"`
+}
+
+// FacebookTokenInfo is a struct representing what Facebook says about an
+// access token when it is debugged.
+type FacebookTokenInfo struct {
+	AppID     string `json:"app_id"`
+	UserID    string `json:"user_id"`
+	IsValid   bool   `json:"is_valid"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// FacebookClient is an interface for anything that can answer the questions
+// AKTVE asks Facebook's Graph API about a User's access token.
+type FacebookClient interface {
+	GetProfile(accessToken string) (FacebookProfile, error)
+	DebugToken(accessToken string) (FacebookTokenInfo, error)
+}
+
+var gFacebookClient FacebookClient = NewGraphFacebookClient(gFacebookGraphURL, gFacebookAppID, gFacebookAppSecret)
+
+// GraphFacebookClient is a FacebookClient that talks to a Graph API server
+// (either Facebook's own or a stand-in like FakeGraphServer).
+type GraphFacebookClient struct {
+	BaseURL   string // e.g. "https://graph.facebook.com/v19.0"
+	AppID     string
+	AppSecret string
+	Client    *http.Client
+}
+
+// NewGraphFacebookClient creates a new GraphFacebookClient for the app with the
+// provided ID and secret, talking to the Graph API at the provided URL.
+func NewGraphFacebookClient(baseURL string, appID string, appSecret string) *GraphFacebookClient {
+	return &GraphFacebookClient{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		AppID:     appID,
+		AppSecret: appSecret,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetProfile retrieves the profile of the User that the provided access token
+// belongs to.
+func (o *GraphFacebookClient) GetProfile(accessToken string) (FacebookProfile, error) {
+	var profile FacebookProfile
+
+	params := url.Values{}
+	params.Set("fields", "id,name,birthday,picture.width(640)")
+	params.Set("access_token", accessToken)
+	if o.AppSecret != "" {
+		params.Set("appsecret_proof", FacebookAppSecretProof(o.AppSecret, accessToken))
+	}
+
+	if err := o.get("/me", params, &profile); err != nil {
+		return profile, err
+	}
+	if profile.ID == "" {
+		return profile, errors.New("facebook: profile has no ID")
+	}
+
+	return profile, nil
+}
+
+// DebugToken asks Facebook who the provided access token was issued to, and
+// for which app, using the app's own access token.
+func (o *GraphFacebookClient) DebugToken(accessToken string) (FacebookTokenInfo, error) {
+	var res struct {
+		Data FacebookTokenInfo `json:"data"`
+	}
+
+	params := url.Values{}
+	params.Set("input_token", accessToken)
+	params.Set("access_token", o.AppID+"|"+o.AppSecret)
+
+	if err := o.get("/debug_token", params, &res); err != nil {
+		return res.Data, err
+	}
+
+	return res.Data, nil
+}
+
+// get makes a GET request to the Graph API and decodes the JSON response into
+// the provided value. Graph API errors are turned into Go errors, rather than
+// being decoded.
+func (o *GraphFacebookClient) get(path string, params url.Values, value interface{}) error {
+	res, err := o.Client.Get(o.BaseURL + path + "?" + params.Encode())
+	if err != nil {
+		return errors.New("facebook: failed to reach Graph API")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var graphError struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&graphError); err == nil && graphError.Error.Message != "" {
+			return errors.New("facebook: " + graphError.Error.Message)
+		}
+
+		return errors.New("facebook: Graph API responded with " + res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(value); err != nil {
+		return errors.New("facebook: malformed Graph API response")
+	}
+
+	return nil
+}
+
+// FacebookAppSecretProof returns the "appsecret_proof" for the provided access
+// token, which proves to Facebook that a request was made by the app's own
+// server rather than by someone who stole a token.
+func FacebookAppSecretProof(appSecret string, accessToken string) string {
+	return hex.EncodeToString(hmacSHA256([]byte(appSecret), accessToken))
+}
+
+// FakeGraphServer is a stand-in for Facebook's Graph API, for local testing.
+// It accepts any access token of the form "test-<user ID>" and makes up a
+// profile for it. Tokens of the form "test-<user ID>@<app ID>" are treated as
+// issued to another app. (NOTE: This must never be used in production.)
+type FakeGraphServer struct {
+	AppID     string
+	AppSecret string
+}
+
+// ServeHTTP handles the "/me" and "/debug_token" Graph API endpoints.
+func (o *FakeGraphServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	query := r.URL.Query()
+	path := r.URL.Path[strings.LastIndex(r.URL.Path, "/"):]
+
+	switch path {
+	case "/me":
+		accessToken := query.Get("access_token")
+		userID, _, ok := o.parseToken(accessToken)
+		if !ok {
+			o.error(w, http.StatusBadRequest, "Invalid OAuth access token.")
+			return
+		}
+		if o.AppSecret != "" && !hmac.Equal([]byte(query.Get("appsecret_proof")), []byte(FacebookAppSecretProof(o.AppSecret, accessToken))) {
+			o.error(w, http.StatusBadRequest, "Invalid appsecret_proof provided in the API argument")
+			return
+		}
+
+		profile := FacebookProfile{ID: userID, Name: "Test User " + userID, Birthday: "01/01/1990"}
+		json.NewEncoder(w).Encode(profile)
+	case "/debug_token":
+		if query.Get("access_token") != o.AppID+"|"+o.AppSecret {
+			o.error(w, http.StatusBadRequest, "Invalid app access token.")
+			return
+		}
+
+		var info FacebookTokenInfo
+		if userID, appID, ok := o.parseToken(query.Get("input_token")); ok {
+			info = FacebookTokenInfo{AppID: appID, UserID: userID, IsValid: true, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+		}
+		json.NewEncoder(w).Encode(struct {
+			Data FacebookTokenInfo `json:"data"`
+		}{info})
+	default:
+		o.error(w, http.StatusNotFound, "Unknown path components: "+r.URL.Path)
+	}
+}
+
+// parseToken returns the ID of the User and app that the provided fake access
+// token was issued to, and whether it is a fake access token at all.
+func (o *FakeGraphServer) parseToken(accessToken string) (string, string, bool) {
+	if !strings.HasPrefix(accessToken, "test-") {
+		return "", "", false
+	}
+
+	userID, appID, found := strings.Cut(strings.TrimPrefix(accessToken, "test-"), "@")
+	if !found {
+		appID = o.AppID
+	}
+	if userID == "" || (found && appID == "") {
+		return "", "", false
+	}
+
+	return userID, appID, true
+}
+
+// error writes a Graph API style error response.
+func (o *FakeGraphServer) error(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+
+	var res struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	res.Error.Message = message
+	res.Error.Type = "OAuthException"
+
+	json.NewEncoder(w).Encode(res)
+}
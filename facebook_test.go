@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// mismatchedFacebookClient is a FacebookClient whose profiles belong to a
+// different User than the one their access token was issued to.
+type mismatchedFacebookClient struct {
+	FacebookClient
+}
+
+func (o *mismatchedFacebookClient) GetProfile(accessToken string) (FacebookProfile, error) {
+	profile, err := o.FacebookClient.GetProfile(accessToken)
+	profile.ID = "someone-else"
+
+	return profile, err
+}
+
+// useFakeGraphServer points the Facebook client at a new FakeGraphServer for
+// the app with the provided ID, until the test is over.
+func useFakeGraphServer(t *testing.T, appID string) {
+	server := httptest.NewServer(&FakeGraphServer{AppID: appID, AppSecret: "test-secret"})
+
+	oldClient, oldAppID := gFacebookClient, gFacebookAppID
+	gFacebookClient = NewGraphFacebookClient(server.URL, appID, "test-secret")
+	gFacebookAppID = appID
+
+	t.Cleanup(func() {
+		server.Close()
+		gFacebookClient, gFacebookAppID = oldClient, oldAppID
+	})
+}
+
+func TestFacebookIdentityProviderAcceptsTokenForApp(t *testing.T) {
+	useFakeGraphServer(t, "aktve")
+
+	provider := &FacebookIdentityProvider{}
+	identity, err := provider.Authenticate(url.Values{"fb_access_token": {"test-1234"}})
+	if err != nil {
+		t.Fatalf("Authenticate returned an error: %v", err)
+	}
+	if identity.Provider != "facebook" || identity.Subject != "1234" {
+		t.Errorf("Authenticate returned %q/%q, expected facebook/1234", identity.Provider, identity.Subject)
+	}
+	if identity.Birthdate == nil || !identity.BirthdateVerified {
+		t.Errorf("Authenticate didn't return the verified birthdate")
+	}
+}
+
+func TestFacebookIdentityProviderRejectsTokenForOtherApp(t *testing.T) {
+	useFakeGraphServer(t, "aktve")
+
+	provider := &FacebookIdentityProvider{}
+	if _, err := provider.Authenticate(url.Values{"fb_access_token": {"test-1234@some-other-app"}}); err == nil {
+		t.Fatal("Authenticate accepted an access token issued to another app")
+	}
+}
+
+func TestFacebookIdentityProviderRejectsTokenForOtherUser(t *testing.T) {
+	useFakeGraphServer(t, "aktve")
+	gFacebookClient = &mismatchedFacebookClient{gFacebookClient}
+
+	provider := &FacebookIdentityProvider{}
+	if _, err := provider.Authenticate(url.Values{"fb_access_token": {"test-1234"}}); err == nil {
+		t.Fatal("Authenticate accepted an access token issued to a different User than the profile")
+	}
+}
+
+func TestFacebookIdentityProviderRejectsInvalidToken(t *testing.T) {
+	useFakeGraphServer(t, "aktve")
+
+	provider := &FacebookIdentityProvider{}
+	if _, err := provider.Authenticate(url.Values{"fb_access_token": {"not-a-test-token"}}); err == nil {
+		t.Fatal("Authenticate accepted an invalid access token")
+	}
+}
@@ -1,14 +1,543 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// File is a struct representing a File in the database.
+// File is a struct representing a File in the database. The File itself is
+// just a metadata record; its contents are kept in the BlobStore.
 type File struct {
-	ID     bson.ObjectId `json:"_id" bson:"_id"`
-	Data   []byte        `json:"data" bson:"data"`
-	Type   string        `json:"type" bson:"type"`
-	Length int           `json:"length" bson:"length"`
-	MD5    []byte        `json:"md5" bson:"md5"`
+	ID               bson.ObjectId            `json:"_id" bson:"_id"`
+	Data             []byte                   `json:"-" bson:"data,omitempty"` // (NOTE: Only set for Files stored before the BlobStore was introduced.)
+	Type             string                   `json:"type" bson:"type"`
+	Length           int                      `json:"length" bson:"length"`
+	SHA256           string                   `json:"sha256" bson:"sha256"`
+	RefCount         int                      `json:"-" bson:"ref_count"`               // The number of places (e.g. Users' images) that reference this File
+	LastUploaded     time.Time                `json:"-" bson:"last_uploaded,omitempty"` // When this File was last uploaded by anyone (NOTE: Not set for Files stored before this was recorded.)
+	OwnerIDs         []int                    `json:"-" bson:"owner_ids"`               // The IDs of the Users that uploaded this File
+	Width            int                      `json:"width,omitempty" bson:"width,omitempty"`
+	Height           int                      `json:"height,omitempty" bson:"height,omitempty"`
+	Renditions       map[string]bson.ObjectId `json:"renditions,omitempty" bson:"renditions,omitempty"`     // The other renditions of this image, by name
+	RenditionOf      bson.ObjectId            `json:"rendition_of,omitempty" bson:"rendition_of,omitempty"` // The File that this File is a rendition of, if it is one
+	Moderation       string                   `json:"moderation,omitempty" bson:"moderation,omitempty"`     // Either "pending", "approved" or "rejected" (Files stored before moderation was introduced have none, and count as approved)
+	ModerationReason string                   `json:"moderation_reason,omitempty" bson:"moderation_reason,omitempty"`
+}
+
+// HashData returns the hex-encoded SHA-256 hash of the provided data.
+func HashData(data []byte) string {
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// StoreImage processes the provided raw uploaded image data from the User with
+// the provided ID, stores each of the resulting renditions in the database, and
+// returns the File of the full rendition (which links to the rest). If an
+// identical image has already been stored, its File is reused instead.
+//
+// The returned File is owned by the User, but isn't referenced by anything
+// yet; use RetainFile (or User.SetImages) once it is.
+func StoreImage(data []byte, ownerID int) (File, error) {
+	renditions, err := ProcessImage(data)
+	if err != nil {
+		return File{}, err
+	}
+
+	// See if the image has already been stored (NOTE: Processing is
+	// deterministic, so identical uploads result in identical renditions.)
+	var full File
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	// (NOTE: Reusing a File counts as uploading it again, so that the garbage
+	// collector leaves it alone until the User has had time to reference it.)
+	change := mgo.Change{
+		Update:    bson.M{"$addToSet": bson.M{"owner_ids": ownerID}, "$set": bson.M{"last_uploaded": time.Now()}},
+		ReturnNew: true,
+	}
+	query := bson.M{"sha256": HashData(renditions["full"].Data), "rendition_of": bson.M{"$exists": false}}
+	if _, err := c.Find(query).Apply(change, &full); err == nil {
+		return full, nil
+	}
+
+	// Create a File for each of the renditions
+	files := map[string]File{}
+	for name, rendition := range renditions {
+		files[name] = File{
+			ID:       bson.NewObjectId(),
+			Type:     "image/jpeg",
+			Length:   len(rendition.Data),
+			SHA256:   HashData(rendition.Data),
+			RefCount: 0,
+			OwnerIDs: []int{ownerID},
+			Width:    rendition.Width,
+			Height:   rendition.Height,
+		}
+	}
+
+	// Link the full rendition and the rest of the renditions together, and
+	// pre-screen the full rendition for moderation
+	full = files["full"]
+	full.LastUploaded = time.Now()
+	full.Moderation, full.ModerationReason = gImageClassifier.Classify(full, renditions["full"].Data)
+	full.Renditions = map[string]bson.ObjectId{}
+	for name, file := range files {
+		if name != "full" {
+			full.Renditions[name] = file.ID
+			file.RenditionOf = full.ID
+			files[name] = file
+		}
+	}
+	files["full"] = full
+
+	// Push the Files into the database before their contents go into the
+	// BlobStore, so that no blob is ever left without a File (NOTE: The full
+	// rendition goes first, so that the garbage collector can find the rest
+	// through it if this doesn't get any further. It is stored without its
+	// content hash until its contents are in place, so that identical uploads
+	// can't reuse it before then.)
+	pending := full
+	pending.SHA256 = ""
+	if err := c.Insert(pending); err != nil {
+		return File{}, errors.New("failed to push image into database")
+	}
+	for name, file := range files {
+		if name == "full" {
+			continue
+		}
+
+		if err := c.Insert(file); err != nil {
+			RemoveFile(full.ID)
+			return File{}, errors.New("failed to push image into database")
+		}
+	}
+
+	for name, file := range files {
+		if err := gBlobStore.Put(file.ID.Hex(), renditions[name].Data, file.Type); err != nil {
+			RemoveFile(full.ID)
+			return File{}, err
+		}
+	}
+
+	if err := c.UpdateId(full.ID, bson.M{"$set": bson.M{"sha256": full.SHA256}}); err != nil {
+		RemoveFile(full.ID)
+		return File{}, errors.New("failed to push image into database")
+	}
+
+	return full, nil
+}
+
+// IsFileOwnedBy returns whether the File with the provided ID was uploaded by
+// the User with the provided ID.
+func IsFileOwnedBy(id bson.ObjectId, ownerID int) bool {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if cnt, err := c.Find(bson.M{"_id": id, "owner_ids": ownerID}).Count(); err == nil && cnt > 0 {
+		return true
+	}
+
+	return false
+}
+
+// IsFileOwned returns whether the File with the provided ID has any owners
+// recorded at all.
+func IsFileOwned(id bson.ObjectId) bool {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if cnt, err := c.Find(bson.M{"_id": id, "owner_ids.0": bson.M{"$exists": true}}).Count(); err == nil && cnt > 0 {
+		return true
+	}
+
+	return false
+}
+
+// GetUnapprovedFileIDs returns which of the Files with the provided IDs are not
+// (or not yet) approved by moderation.
+func GetUnapprovedFileIDs(ids []bson.ObjectId) (map[bson.ObjectId]bool, error) {
+	var files []File
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	query := bson.M{"_id": bson.M{"$in": ids}, "moderation": bson.M{"$in": []string{"pending", "rejected"}}}
+	if err := c.Find(query).Select(bson.M{"_id": 1}).All(&files); err != nil {
+		return nil, errors.New("failed to retrieve File moderation states")
+	}
+
+	unapproved := map[bson.ObjectId]bool{}
+	for _, file := range files {
+		unapproved[file.ID] = true
+	}
+
+	return unapproved, nil
+}
+
+// IsViewableBy returns whether the provided User may view the File. Files that
+// haven't been approved by moderation (or have been rejected) can only be
+// viewed by their owners and by administrators.
+func (o *File) IsViewableBy(viewer User) (bool, error) {
+	// (NOTE: Renditions are moderated along with the File they belong to.)
+	file := *o
+	if o.RenditionOf != "" {
+		db := gDatabase.Copy()
+		defer db.Close()
+
+		c := db.DB(dbDB).C("files")
+		if err := c.FindId(o.RenditionOf).One(&file); err != nil {
+			return false, errors.New("could not find File that rendition belongs to")
+		}
+	}
+
+	if file.Moderation != "pending" && file.Moderation != "rejected" {
+		return true, nil
+	}
+	if viewer.Admin {
+		return true, nil
+	}
+	for _, ownerID := range file.OwnerIDs {
+		if ownerID == viewer.ID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// RetainFile adds the provided number of references to the File with the
+// provided ID.
+func RetainFile(id bson.ObjectId, count int) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if err := c.UpdateId(id, bson.M{"$inc": bson.M{"ref_count": count}}); err != nil {
+		return errors.New("failed to retain File")
+	}
+
+	return nil
+}
+
+// ReleaseFile drops the provided number of references to the File with the
+// provided ID. (NOTE: The File isn't deleted here once nothing references it
+// anymore, as someone may be reusing it for an identical upload right now.
+// CollectGarbageFiles deletes it once it has gone unreferenced for long
+// enough.)
+func ReleaseFile(id bson.ObjectId, count int) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if err := c.UpdateId(id, bson.M{"$inc": bson.M{"ref_count": -count}}); err != nil && err != mgo.ErrNotFound {
+		return errors.New("failed to release File")
+	}
+
+	return nil
+}
+
+// RemoveFile removes the File with the provided ID, along with its renditions
+// and their contents in the BlobStore.
+func RemoveFile(id bson.ObjectId) error {
+	var files []File
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	query := bson.M{"$or": []bson.M{{"_id": id}, {"rendition_of": id}}}
+	if err := c.Find(query).Select(bson.M{"_id": 1}).All(&files); err != nil {
+		return errors.New("failed to find File")
+	}
+
+	// (NOTE: The File's own blob is always deleted, even if its record is
+	// already gone, e.g. because the garbage collector removed it first.)
+	if err := gBlobStore.Delete(id.Hex()); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.ID == id {
+			continue
+		}
+
+		if err := gBlobStore.Delete(file.ID.Hex()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.RemoveAll(query); err != nil {
+		return errors.New("failed to remove File")
+	}
+
+	return nil
+}
+
+// DisownFile removes the User with the provided ID from the owners of the File
+// with the provided ID.
+func DisownFile(id bson.ObjectId, ownerID int) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if err := c.UpdateId(id, bson.M{"$pull": bson.M{"owner_ids": ownerID}}); err != nil && err != mgo.ErrNotFound {
+		return errors.New("failed to disown File")
+	}
+
+	return nil
+}
+
+// CollectGarbageFiles removes every File that is no longer referenced by
+// anything (along with its renditions). Files that were uploaded (or reused by
+// an identical upload) more recently than the provided age ago are left alone,
+// as they may not have been referenced yet.
+func CollectGarbageFiles(minAge time.Duration) (int, error) {
+	// Gather up every File that is referenced by a User (NOTE: Files stored
+	// before reference counting was introduced have no reference count, so
+	// Users' images are checked as well.)
+	var users []User
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("users")
+	if err := c.Find(nil).Select(bson.M{"images": 1}).All(&users); err != nil {
+		return 0, errors.New("failed to retrieve Users' images")
+	}
+
+	referenced := map[bson.ObjectId]bool{}
+	for _, user := range users {
+		for _, element := range user.Images {
+			if fileID, ok := FileIDFromURL(element); ok {
+				referenced[fileID] = true
+			}
+		}
+	}
+
+	// Find every File (that isn't a rendition) that has no references and
+	// hasn't been uploaded recently
+	cutoff := time.Now().Add(-minAge)
+	unreferenced := bson.M{
+		"rendition_of": bson.M{"$exists": false},
+		"ref_count":    bson.M{"$not": bson.M{"$gt": 0}},
+		"$or": []bson.M{
+			{"last_uploaded": bson.M{"$lt": cutoff}},
+			{"last_uploaded": bson.M{"$exists": false}, "_id": bson.M{"$lt": bson.NewObjectIdWithTime(cutoff)}},
+		},
+	}
+
+	var file File
+	removed := 0
+
+	c = db.DB(dbDB).C("files")
+	iter := c.Find(unreferenced).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&file) {
+		if referenced[file.ID] {
+			continue
+		}
+
+		// Only remove the File if it is still unreferenced and not recently
+		// uploaded, in case it was retained or reused since it was found
+		query := bson.M{"_id": file.ID}
+		for key, value := range unreferenced {
+			query[key] = value
+		}
+		if err := c.Remove(query); err == mgo.ErrNotFound {
+			continue
+		} else if err != nil {
+			iter.Close()
+			return removed, errors.New("failed to remove File")
+		}
+
+		if err := RemoveFile(file.ID); err != nil {
+			iter.Close()
+			return removed, err
+		}
+		removed++
+	}
+	if err := iter.Close(); err != nil {
+		return removed, errors.New("failed to iterate over Files")
+	}
+
+	return removed, nil
+}
+
+// StartFileGarbageCollector periodically removes Files that are no longer
+// referenced by anything, until the provided channel is closed. It should be
+// run as a goroutine.
+func StartFileGarbageCollector(interval time.Duration, stop <-chan struct{}) {
+	for {
+		if removed, err := CollectGarbageFiles(time.Hour); err != nil {
+			slog.Error("There was an issue collecting unreferenced files", "error", err)
+		} else if removed > 0 {
+			slog.Info("Removed unreferenced files", "count", removed)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ReadData retrieves the contents of the File from the BlobStore.
+func (o *File) ReadData() ([]byte, error) {
+	// (NOTE: Files stored before the BlobStore was introduced still have their
+	// contents in the database.)
+	if len(o.Data) > 0 {
+		return o.Data, nil
+	}
+
+	return gBlobStore.Get(o.ID.Hex())
+}
+
+// Verify checks that the provided contents of the File still match its content
+// hash. Files that were stored before content hashes were recorded have theirs
+// recorded now instead.
+func (o *File) Verify(data []byte) error {
+	hash := HashData(data)
+
+	if o.SHA256 == "" {
+		o.SHA256 = hash
+
+		db := gDatabase.Copy()
+		defer db.Close()
+
+		c := db.DB(dbDB).C("files")
+		if err := c.UpdateId(o.ID, bson.M{"$set": bson.M{"sha256": hash}}); err != nil {
+			return errors.New("failed to record File hash")
+		}
+	} else if o.SHA256 != hash {
+		return errors.New("file: data does not match content hash")
+	}
+
+	return nil
+}
+
+// GetRendition returns the File for the rendition of the File with the provided
+// name (e.g. "thumbnail"). If the File doesn't have such a rendition (e.g. it
+// was uploaded before renditions were generated), the File itself is returned.
+func (o *File) GetRendition(name string) (File, error) {
+	id, ok := o.Renditions[name]
+	if !ok {
+		return *o, nil
+	}
+
+	var file File
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if err := c.FindId(id).One(&file); err != nil {
+		return File{}, errors.New("could not find rendition of File")
+	}
+
+	return file, nil
+}
+
+// ETagMatches returns whether the provided If-None-Match header value matches
+// the provided (unquoted) entity tag.
+func ETagMatches(header string, etag string) bool {
+	for _, element := range strings.Split(header, ",") {
+		element = strings.TrimPrefix(strings.TrimSpace(element), "W/")
+		if element == "*" || element == ("\""+etag+"\"") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FileURL returns the URL that the File with the provided ID is served at.
+func FileURL(id bson.ObjectId) string {
+	return gAPIURL + "/file/" + id.Hex()
+}
+
+// FileIDFromURL returns the ID of the File that the provided URL points to, if
+// it points to a File served by this API server at all. (NOTE: Users' images
+// may also point elsewhere, e.g. to their Facebook profile pictures.) Any query
+// string on the URL (e.g. a signature) is ignored.
+func FileIDFromURL(url string) (bson.ObjectId, bool) {
+	prefix := gAPIURL + "/file/"
+	if !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+
+	id := strings.TrimPrefix(url, prefix)
+	if index := strings.Index(id, "?"); index > -1 {
+		id = id[:index]
+	}
+	if !bson.IsObjectIdHex(id) {
+		return "", false
+	}
+
+	return bson.ObjectIdHex(id), true
+}
+
+// SignFileURL returns a copy of the provided URL that allows the User with the
+// provided ID to view the File it points to until the URL expires. URLs that
+// don't point to Files served by this API server are returned as they are.
+//
+// Expiry times are rounded up to the hour, so that the same User is handed the
+// same URL for a while and can cache the File.
+func SignFileURL(url string, viewerID int) string {
+	fileID, ok := FileIDFromURL(url)
+	if !ok {
+		return url
+	}
+
+	expires := time.Now().Truncate(time.Hour).Add(time.Hour + gFileURLLifetime).Unix()
+	signature := fileURLSignature(fileID, viewerID, expires)
+
+	return FileURL(fileID) + "?expires=" + strconv.FormatInt(expires, 10) + "&viewer=" + strconv.Itoa(viewerID) + "&signature=" + signature
+}
+
+// VerifyFileURL checks that the provided query string values of a request for
+// the File with the provided ID carry a valid, unexpired signature, and returns
+// the ID of the User that the URL was signed for along with when it expires.
+func VerifyFileURL(fileID bson.ObjectId, query url.Values) (int, time.Time, error) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return -1, time.Time{}, errors.New("file: URL is not signed")
+	}
+	viewerID, err := strconv.Atoi(query.Get("viewer"))
+	if err != nil {
+		return -1, time.Time{}, errors.New("file: URL is not signed")
+	}
+
+	signature := fileURLSignature(fileID, viewerID, expires)
+	if !hmac.Equal([]byte(signature), []byte(query.Get("signature"))) {
+		return -1, time.Time{}, errors.New("file: URL signature is invalid")
+	}
+	if time.Now().Unix() > expires {
+		return -1, time.Time{}, errors.New("file: URL has expired")
+	}
+
+	return viewerID, time.Unix(expires, 0), nil
+}
+
+// fileURLSignature returns the hex-encoded HMAC-SHA256 signature that allows
+// the User with the provided ID to view the File with the provided ID until
+// the provided Unix time.
+func fileURLSignature(fileID bson.ObjectId, viewerID int, expires int64) string {
+	message := fileID.Hex() + ":" + strconv.Itoa(viewerID) + ":" + strconv.FormatInt(expires, 10)
+
+	return hex.EncodeToString(hmacSHA256(gFileURLSecret, message))
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// signedFileQuery returns the query string values of a URL for the File with
+// the provided ID, signed for the provided viewer by SignFileURL.
+func signedFileQuery(t *testing.T, fileID bson.ObjectId, viewerID int) url.Values {
+	u, err := url.Parse(SignFileURL(FileURL(fileID), viewerID))
+	if err != nil {
+		t.Fatalf("SignFileURL returned an invalid URL: %v", err)
+	}
+
+	return u.Query()
+}
+
+func TestVerifyFileURL(t *testing.T) {
+	fileID := bson.NewObjectId()
+	otherFileID := bson.NewObjectId()
+
+	expired := time.Now().Add(-time.Minute).Unix()
+	expiredQuery := url.Values{
+		"expires":   {strconv.FormatInt(expired, 10)},
+		"viewer":    {"42"},
+		"signature": {fileURLSignature(fileID, 42, expired)},
+	}
+
+	tests := []struct {
+		name   string
+		fileID bson.ObjectId
+		query  func() url.Values
+		valid  bool
+	}{
+		{"signed", fileID, func() url.Values { return signedFileQuery(t, fileID, 42) }, true},
+		{"expired", fileID, func() url.Values { return expiredQuery }, false},
+		{"wrong viewer", fileID, func() url.Values {
+			query := signedFileQuery(t, fileID, 42)
+			query.Set("viewer", "43")
+			return query
+		}, false},
+		{"extended expiry", fileID, func() url.Values {
+			query := signedFileQuery(t, fileID, 42)
+			query.Set("expires", strconv.FormatInt(time.Now().Add(365*24*time.Hour).Unix(), 10))
+			return query
+		}, false},
+		{"tampered signature", fileID, func() url.Values {
+			query := signedFileQuery(t, fileID, 42)
+			signature := []byte(query.Get("signature"))
+			if signature[0] == '0' {
+				signature[0] = '1'
+			} else {
+				signature[0] = '0'
+			}
+			query.Set("signature", string(signature))
+			return query
+		}, false},
+		{"other file", otherFileID, func() url.Values { return signedFileQuery(t, fileID, 42) }, false},
+		{"missing signature", fileID, func() url.Values {
+			query := signedFileQuery(t, fileID, 42)
+			query.Del("signature")
+			return query
+		}, false},
+		{"missing viewer", fileID, func() url.Values {
+			query := signedFileQuery(t, fileID, 42)
+			query.Del("viewer")
+			return query
+		}, false},
+		{"missing expiry", fileID, func() url.Values {
+			query := signedFileQuery(t, fileID, 42)
+			query.Del("expires")
+			return query
+		}, false},
+		{"unsigned", fileID, func() url.Values { return url.Values{} }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viewerID, expires, err := VerifyFileURL(test.fileID, test.query())
+			if test.valid && err != nil {
+				t.Fatalf("VerifyFileURL returned an error: %v", err)
+			} else if !test.valid && err == nil {
+				t.Fatal("VerifyFileURL accepted an invalid URL")
+			}
+
+			if test.valid {
+				if viewerID != 42 {
+					t.Errorf("VerifyFileURL returned viewer %d, expected 42", viewerID)
+				}
+				if !expires.After(time.Now().Add(gFileURLLifetime)) {
+					t.Errorf("VerifyFileURL returned an expiry of %v, expected at least %v from now", expires, gFileURLLifetime)
+				}
+			}
+		})
+	}
+}
+
+func TestSignFileURLLeavesOtherURLsAlone(t *testing.T) {
+	for _, element := range []string{"https://graph.facebook.com/1234/picture", gAPIURL + "/file/not-an-id", ""} {
+		if signed := SignFileURL(element, 42); signed != element {
+			t.Errorf("SignFileURL(%q) returned %q, expected it unchanged", element, signed)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`"def"`, false},
+		{`abc`, false}, // (NOTE: Entity tags have to be quoted.)
+		{`*`, true},
+		{` * `, true},
+		{`W/"abc"`, true}, // (NOTE: If-None-Match uses weak comparison.)
+		{`W/"def"`, false},
+		{`"def", "abc"`, true},
+		{`"def","ghi"`, false},
+		{`"def", W/"abc", "ghi"`, true},
+		{`"def", *`, true},
+		{`"ab"`, false},
+		{`"abcd"`, false},
+		{``, false},
+	}
+
+	for _, test := range tests {
+		if got := ETagMatches(test.header, "abc"); got != test.want {
+			t.Errorf("ETagMatches(%q, \"abc\") returned %v, expected %v", test.header, got, test.want)
+		}
+	}
+}
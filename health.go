@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Declare the build information of the API server (NOTE: These are set at
+// build time with "-ldflags -X", e.g. by update.sh. If they aren't, whatever
+// the Go toolchain recorded about the build is used instead.)
+var gBuildCommit = "unknown"
+var gBuildTime = "unknown"
+
+// BuildInfo is a model used to represent which build of the API server is
+// running.
+type BuildInfo struct {
+	Commit    string `json:"commit"`
+	Time      string `json:"time"`
+	GoVersion string `json:"go_version"`
+}
+
+// GetBuildInfo returns the build information of the running API server.
+func GetBuildInfo() BuildInfo {
+	info := BuildInfo{Commit: gBuildCommit, Time: gBuildTime, GoVersion: runtime.Version()}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "unknown" {
+				info.Commit = setting.Value
+			} else if setting.Key == "vcs.time" && info.Time == "unknown" {
+				info.Time = setting.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// DependencyStatus is a model used to represent the health of something that
+// the API server depends on.
+type DependencyStatus struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"` // Either "ok" or "error"
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Dependency is a struct representing something that the API server depends
+// on, along with how to check that it is working.
+type Dependency struct {
+	Name  string
+	Check func() error
+}
+
+var gDependencies = []Dependency{
+	{"database", func() error { return gDatabase.DatabaseTest() }},
+	{"blob_store", func() error {
+		if gBlobStore == nil {
+			return errors.New("blob: blob store not set up")
+		}
+
+		return gBlobStore.Check()
+	}},
+}
+
+// CheckDependencies checks all of the API server's dependencies at once, each
+// of which has the provided amount of time to respond. Whether all of them are
+// working is returned along with their individual statuses.
+func CheckDependencies(timeout time.Duration) (bool, []DependencyStatus) {
+	results := make([]chan DependencyStatus, len(gDependencies))
+	for index, dependency := range gDependencies {
+		results[index] = make(chan DependencyStatus, 1)
+
+		go func(dependency Dependency, result chan DependencyStatus) {
+			start := time.Now()
+			status := DependencyStatus{Name: dependency.Name, Status: "ok"}
+			if err := dependency.Check(); err != nil {
+				status.Status = "error"
+				status.Error = err.Error()
+			}
+			status.Latency = float64(time.Since(start).Microseconds()) / 1000
+
+			result <- status
+		}(dependency, results[index])
+	}
+
+	// (NOTE: A dependency that hangs counts as broken, but its check is left to
+	// finish in the background.)
+	healthy := true
+	statuses := []DependencyStatus{}
+	deadline := time.Now().Add(timeout)
+	for index, result := range results {
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case status := <-result:
+			statuses = append(statuses, status)
+		case <-timer.C:
+			// (NOTE: The check may have finished just as time ran out.)
+			select {
+			case status := <-result:
+				statuses = append(statuses, status)
+			default:
+				statuses = append(statuses, DependencyStatus{Name: gDependencies[index].Name, Status: "error", Latency: float64(timeout.Milliseconds()), Error: "timed out"})
+			}
+		}
+		timer.Stop()
+
+		if statuses[index].Status != "ok" {
+			healthy = false
+		}
+	}
+
+	return healthy, statuses
+}
+
+// EndpointGETHealthz handles the "GET /healthz" endpoint, which tells whether
+// the API server is alive. (NOTE: This deliberately doesn't check any
+// dependencies, so that the API server isn't restarted just because the
+// database is down.)
+func EndpointGETHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	success := Success{Success: true, Error: ""}
+	returnJSON := struct {
+		Success Success
+	}{success}
+
+	if err := json.NewEncoder(w).Encode(returnJSON); err != nil {
+		panic(err)
+	}
+}
+
+// EndpointGETReadyz handles the "GET /readyz" endpoint, which tells whether
+// the API server can currently serve requests, along with the status of each
+// of its dependencies. (NOTE: Unlike the rest of the API, this responds with
+// 503 when it isn't ready, since that is what load balancers look at.)
+func EndpointGETReadyz(w http.ResponseWriter, r *http.Request) {
+	// Define the data response of the API call
+	type GenericData struct {
+		Dependencies []DependencyStatus `json:"dependencies"`
+		Caches       map[string]int     `json:"caches"`
+	}
+	type ReturnData struct {
+		Success Success
+		Data    GenericData
+	}
+
+	var success Success
+	var data GenericData
+
+	ready, statuses := CheckDependencies(gReadinessTimeout)
+	data.Dependencies = statuses
+	data.Caches = map[string]int{
+		"users":    len(gUserCache.Users),
+		"sessions": len(gSessionCache.Sessions),
+	}
+
+	status := http.StatusOK
+	if ready {
+		success.Success = true
+		success.Error = ""
+	} else {
+		status = http.StatusServiceUnavailable
+		success.Success = false
+		success.Error = "Not ready. One or more dependencies are unavailable."
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+
+	returnJSON := ReturnData{Success: success, Data: data}
+	if err := json.NewEncoder(w).Encode(returnJSON); err != nil {
+		panic(err)
+	}
+}
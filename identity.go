@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Identity is a struct representing who a User of AKTVE is according to an
+// IdentityProvider.
+type Identity struct {
+	Provider  string   // The name of the IdentityProvider
+	Subject   string   // The provider's (stable) ID for the User
+	Name      string   // (NOTE: Only some providers know this.)
+	Email     string   // (NOTE: Only some providers know this.)
+	ImageURLs []string // (NOTE: Only some providers know this.)
+
+	Birthdate         *time.Time // (NOTE: Only some providers know this, and only if the User lets them share it.)
+	BirthdateVerified bool       // Whether the birthdate came from the provider rather than the User
+}
+
+// IdentityLink is a struct representing a link between a User of AKTVE and an
+// Identity that they can log in with.
+type IdentityLink struct {
+	UserID   int       `json:"-" bson:"user_id"`
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email,omitempty" bson:"email,omitempty"`
+	Date     time.Time `json:"date" bson:"date"`
+
+	// (NOTE: These are only used by "password" links.)
+	PasswordHash   string     `json:"-" bson:"password_hash,omitempty"`
+	Verified       bool       `json:"verified,omitempty" bson:"verified,omitempty"` // Whether the User has proven that they own the email address
+	FailedAttempts int        `json:"-" bson:"failed_attempts,omitempty"`
+	LockedUntil    *time.Time `json:"-" bson:"locked_until,omitempty"`
+}
+
+// IdentityProvider is an interface for anything that can tell us who a User of
+// AKTVE is (e.g. Facebook), based on the credentials that the User provides to
+// "POST /login".
+type IdentityProvider interface {
+	Authenticate(credentials url.Values) (Identity, error)
+}
+
+var gIdentityProviders = map[string]IdentityProvider{}
+
+// RegisterIdentityProviders sets up all of the IdentityProviders that are
+// enabled by the current configuration.
+func RegisterIdentityProviders() error {
+	// (NOTE: Facebook access tokens can't be checked without the app's ID and
+	// secret, and unchecked tokens could have been issued to any app, so they
+	// are required unless the fake Graph API is being used.)
+	if (gFacebookAppID == "" || gFacebookAppSecret == "") && gFakeFacebookGraphAddress == "" {
+		return errors.New("identity: AKTVE_FACEBOOK_APP_ID and AKTVE_FACEBOOK_APP_SECRET must be set")
+	}
+
+	gIdentityProviders["facebook"] = &FacebookIdentityProvider{}
+	gIdentityProviders["email"] = &EmailIdentityProvider{}
+	if gAppleClientID != "" {
+		gIdentityProviders["apple"] = NewAppleIdentityProvider(gAppleClientID)
+	}
+	if gGoogleClientID != "" {
+		gIdentityProviders["google"] = NewGoogleIdentityProvider(gGoogleClientID)
+	}
+	if gFakeIdentityProvider {
+		slog.Warn("identity: the fake identity provider is enabled, so anyone can log in as anyone")
+		gIdentityProviders["fake"] = &FakeIdentityProvider{}
+	}
+
+	return nil
+}
+
+// GetIdentityProvider returns the enabled IdentityProvider with the provided
+// name.
+func GetIdentityProvider(name string) (IdentityProvider, error) {
+	if provider, ok := gIdentityProviders[name]; ok {
+		return provider, nil
+	}
+
+	return nil, errors.New("identity: unknown identity provider")
+}
+
+// LoginWithIdentity returns the ID of the User linked to the provided Identity.
+// If no User is linked to it yet, a new User is created from it.
+func LoginWithIdentity(identity Identity) (int, error) {
+	var link IdentityLink
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if err := c.Find(bson.M{"provider": identity.Provider, "subject": identity.Subject}).One(&link); err == nil {
+		// Use the provider's birthdate if the User's hasn't been verified yet
+		// (e.g. if they have only just let the provider share it)
+		if identity.Birthdate != nil && identity.BirthdateVerified {
+			if user, userCacheIndex, err := gUserCache.GetUser(link.UserID); err == nil && !user.BirthdateVerified {
+				if err := gUserCache.Users[userCacheIndex].SetBirthdate(*identity.Birthdate, true); err == nil {
+					gUserCache.Users[userCacheIndex].UpdateOnboarding()
+					gUserCache.Users[userCacheIndex].Push()
+				} else if err == errUserTooYoung {
+					// (NOTE: The provider has verified that the User is too
+					// young, so record it against their account, which stops
+					// them logging in any other way too, and log them out
+					// everywhere.)
+					gUserCache.Users[userCacheIndex].Birthdate = identity.Birthdate
+					gUserCache.Users[userCacheIndex].BirthdateVerified = true
+					gUserCache.Users[userCacheIndex].UpdateAge()
+					if err := gUserCache.Users[userCacheIndex].Push(); err != nil {
+						return -1, errors.New("failed to record verified birthdate")
+					}
+					gSessionCache.CleanSessions(link.UserID)
+
+					return -1, errUserTooYoung
+				}
+			}
+		}
+
+		if user, _, err := gUserCache.GetUser(link.UserID); err == nil && user.IsUnderage() {
+			return -1, errUserTooYoung
+		}
+
+		return link.UserID, nil
+	} else if err != mgo.ErrNotFound {
+		return -1, errors.New("failed to retrieve identity link")
+	}
+
+	// Create a new User for the Identity, and link the two together
+	user, err := CreateUser(identity)
+	if err != nil {
+		return -1, err
+	}
+	if err := LinkIdentity(user.ID, identity); err != nil {
+		// (NOTE: This will happen if the same Identity signed up twice at once,
+		// so don't leave the second User behind.)
+		db.DB(dbDB).C("users").Remove(bson.M{"id": user.ID})
+
+		return -1, err
+	}
+
+	return user.ID, nil
+}
+
+// LinkIdentity links the provided Identity to the User with the provided ID,
+// so that they can log in with it. An Identity can only be linked to one User.
+func LinkIdentity(userID int, identity Identity) error {
+	link := IdentityLink{
+		UserID:   userID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		Date:     time.Now(),
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if err := c.Insert(link); err != nil {
+		if mgo.IsDup(err) {
+			return errors.New("identity is already linked to a User")
+		}
+
+		return errors.New("failed to link identity")
+	}
+
+	return nil
+}
+
+// errIdentityNotLinked is returned when unlinking an Identity that the User
+// doesn't have.
+var errIdentityNotLinked = errors.New("identity: not linked to the User")
+
+// UnlinkIdentity removes the link between the User with the provided ID and
+// their Identity from the provider with the provided name. A User's last
+// Identity can't be unlinked, as they would have no way to log in anymore.
+func UnlinkIdentity(userID int, provider string) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+
+	count, err := c.Find(bson.M{"user_id": userID}).Count()
+	if err != nil {
+		return errors.New("failed to retrieve identity links")
+	}
+	if count <= 1 {
+		return errors.New("cannot unlink a User's last identity")
+	}
+
+	info, err := c.RemoveAll(bson.M{"user_id": userID, "provider": provider})
+	if err != nil {
+		return errors.New("failed to unlink identity")
+	}
+	if info.Removed == 0 {
+		return errIdentityNotLinked
+	}
+
+	// Remove the User's link from before there were other identity providers
+	// too, so that older instances of the API server don't keep logging them
+	// in with it
+	if provider == "facebook" {
+		if _, err := db.DB(dbDB).C("fb_links").RemoveAll(bson.M{"user_id": userID}); err != nil {
+			return errors.New("failed to unlink identity")
+		}
+	}
+
+	return nil
+}
+
+// GetIdentityLinks returns all of the Identities that are linked to the User
+// with the provided ID.
+func GetIdentityLinks(userID int) ([]IdentityLink, error) {
+	links := []IdentityLink{}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if err := c.Find(bson.M{"user_id": userID}).Sort("date").All(&links); err != nil {
+		return links, errors.New("failed to retrieve identity links")
+	}
+
+	return links, nil
+}
+
+// MigrateFacebookLinks copies any links from the old "fb_links" collection into
+// the "identity_links" collection, and removes the unencrypted access tokens
+// that used to be stored there. Each link is marked once it has been copied,
+// so that it is only ever copied once (and a link that is later unlinked
+// doesn't come back). (NOTE: The rest of the old collection is left alone, so
+// that older instances of the API server keep working during a deploy.)
+func MigrateFacebookLinks() error {
+	var links []bson.M
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	if err := db.DB(dbDB).C("fb_links").Find(bson.M{"migrated": bson.M{"$ne": true}}).All(&links); err != nil {
+		return errors.New("failed to retrieve Facebook links")
+	}
+
+	c := db.DB(dbDB).C("identity_links")
+	for _, element := range links {
+		var userID int
+		switch value := element["user_id"].(type) {
+		case int:
+			userID = value
+		case int64:
+			userID = int(value)
+		default:
+			continue
+		}
+		if element["fb_user_id"] == nil {
+			continue
+		}
+
+		query := bson.M{"provider": "facebook", "subject": fmt.Sprint(element["fb_user_id"])}
+		change := bson.M{"$setOnInsert": bson.M{"user_id": userID, "date": time.Now()}}
+		if _, err := c.Upsert(query, change); err != nil {
+			return errors.New("failed to migrate Facebook link")
+		}
+		if err := db.DB(dbDB).C("fb_links").UpdateId(element["_id"], bson.M{"$set": bson.M{"migrated": true}}); err != nil {
+			return errors.New("failed to mark Facebook link as migrated")
+		}
+	}
+
+	if _, err := db.DB(dbDB).C("fb_links").UpdateAll(bson.M{"fb_access_token": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"fb_access_token": ""}}); err != nil {
+		return errors.New("failed to remove stored Facebook access tokens")
+	}
+
+	return nil
+}
+
+// RemoveIdentityTokens removes any identity providers' access tokens that were
+// stored with identity links. (NOTE: Access tokens are only needed to identify
+// a User while they log in, and nothing ever calls a provider on a User's
+// behalf afterwards, so they are no longer kept at all rather than being
+// encrypted at rest. A token that isn't stored can't leak, and there is no
+// encryption key to manage. If something ever needs to call a provider later
+// on, it should ask the User for a fresh token, or store this one encrypted.)
+func RemoveIdentityTokens() error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if _, err := c.UpdateAll(bson.M{"token": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"token": ""}}); err != nil {
+		return errors.New("failed to remove stored access tokens")
+	}
+
+	return nil
+}
+
+// FacebookIdentityProvider is an IdentityProvider that identifies Users by
+// their Facebook account.
+type FacebookIdentityProvider struct{}
+
+// Authenticate identifies a User by their "fb_access_token". The token is
+// checked with Facebook to make sure that it was issued to AKTVE (and not some
+// other app that the User has logged in to) for the same User whose profile
+// it returns.
+func (o *FacebookIdentityProvider) Authenticate(credentials url.Values) (Identity, error) {
+	accessToken := credentials.Get("fb_access_token")
+	if accessToken == "" {
+		return Identity{}, errors.New("identity: 'fb_access_token' is required")
+	}
+
+	profile, err := gFacebookClient.GetProfile(accessToken)
+	if err != nil {
+		return Identity{}, errors.New("identity: invalid Facebook access token")
+	}
+
+	info, err := gFacebookClient.DebugToken(accessToken)
+	if err != nil {
+		return Identity{}, errors.New("identity: failed to validate Facebook access token")
+	}
+	if !info.IsValid || info.AppID != gFacebookAppID || info.UserID != profile.ID {
+		return Identity{}, errors.New("identity: Facebook access token was not issued to AKTVE for this User")
+	}
+
+	identity := Identity{Provider: "facebook", Subject: profile.ID, Name: profile.Name}
+
+	// Get birthday (NOTE: Facebook only sends this if the User has granted the
+	// "user_birthday" permission, and may only send the month and day, or only
+	// the year, depending on what the User shares. Only a full date is any use
+	// for working out their age.)
+	if birthdate, err := time.Parse("01/02/2006", profile.Birthday); err == nil {
+		identity.Birthdate = &birthdate
+		identity.BirthdateVerified = true
+	}
+
+	// Get profile picture URL
+	if profile.Picture.Data.URL != "" {
+		identity.ImageURLs = append(identity.ImageURLs, profile.Picture.Data.URL)
+	}
+
+	return identity, nil
+}
+
+// EmailIdentityProvider is an IdentityProvider that identifies Users by their
+// email address, using single-use "magic" links emailed to them (see
+// SendEmailLoginLink).
+type EmailIdentityProvider struct{}
+
+// Authenticate identifies a User by the "email_token" from their login link.
+func (o *EmailIdentityProvider) Authenticate(credentials url.Values) (Identity, error) {
+	if credentials.Get("email_token") == "" {
+		return Identity{}, errors.New("identity: 'email_token' is required")
+	}
+
+	email, err := ConsumeEmailToken(credentials.Get("email_token"), "login")
+	if err != nil {
+		return Identity{}, errors.New("identity: invalid or expired email token")
+	}
+
+	return Identity{Provider: "email", Subject: email, Email: email}, nil
+}
+
+// SendEmailLoginLink emails a single-use login link to the provided address.
+func SendEmailLoginLink(email string) error {
+	token, err := CreateEmailToken(email, "login", gEmailTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := gEmailLoginURL + "?email_token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Tap the link below to log in to AKTVE. It can only be used once, and expires in %s.\n\n%s\n\nIf you didn't ask to log in, you can safely ignore this email.", gEmailTokenLifetime, link)
+
+	return gMailer.Send(NormaliseEmail(email), "Log in to AKTVE", body)
+}
+
+// FakeIdentityProvider is an IdentityProvider for local testing that trusts
+// whoever the User says they are. (NOTE: This must never be enabled in
+// production.)
+type FakeIdentityProvider struct{}
+
+// Authenticate identifies a User by the "fake_subject" that they provide.
+func (o *FakeIdentityProvider) Authenticate(credentials url.Values) (Identity, error) {
+	if credentials.Get("fake_subject") == "" {
+		return Identity{}, errors.New("identity: 'fake_subject' is required")
+	}
+
+	identity := Identity{Provider: "fake", Subject: credentials.Get("fake_subject"), Name: credentials.Get("name"), Email: credentials.Get("email")}
+	if birthdate, err := ParseBirthdate(credentials.Get("birthdate")); err == nil {
+		identity.Birthdate = &birthdate
+		identity.BirthdateVerified = true
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+)
+
+// ImageRendition is a struct representing one of the sizes that uploaded
+// images are stored at.
+type ImageRendition struct {
+	Name    string
+	MaxSize int // The maximum width and height of the rendition, in pixels
+}
+
+// This is a global array of the renditions that are generated for every
+// uploaded image. The "full" rendition is the one that the image's URL points
+// to, and the rest are linked to from it.
+var gImageRenditions = []ImageRendition{
+	{"thumbnail", 160},
+	{"card", 640},
+	{"full", 1600},
+}
+
+// ProcessedImage is a struct representing a single rendition of an uploaded
+// image, after it has been processed.
+type ProcessedImage struct {
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// DetectImageType returns the MIME type of the provided image data based on
+// its magic bytes, or an empty string if it is not a supported type of image.
+func DetectImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A}):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif"
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "image/webp"
+	}
+
+	return ""
+}
+
+// ProcessImage validates and decodes the provided uploaded image data, and
+// then re-encodes it as a JPEG at each of the image renditions. Re-encoding
+// the image strips any EXIF (and thus GPS) metadata from it, so the EXIF
+// orientation of JPEGs is applied to the pixels beforehand.
+func ProcessImage(data []byte) (map[string]ProcessedImage, error) {
+	// Make sure that the image is actually a type of image that we support
+	if DetectImageType(data) == "" {
+		return nil, errors.New("image: unsupported image type")
+	}
+
+	// Check the dimensions of the image before decoding the whole thing, so
+	// that tiny files claiming to be enormous images can't eat up our memory
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("image: failed to decode image")
+	}
+	if config.Width <= 0 || config.Height <= 0 || (config.Width*config.Height) > gMaxImagePixels {
+		return nil, errors.New("image: image dimensions are out of bounds")
+	}
+
+	// Decode the image (NOTE: Only the first frame of animated images is kept.)
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("image: failed to decode image")
+	}
+
+	// Generate each of the renditions
+	renditions := map[string]ProcessedImage{}
+	orientation := jpegOrientation(data)
+
+	for _, rendition := range gImageRenditions {
+		resized := resizeImage(source, rendition.MaxSize)
+		oriented := orientImage(resized, orientation)
+
+		var buffer bytes.Buffer
+		if err := jpeg.Encode(&buffer, oriented, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, errors.New("image: failed to encode image")
+		}
+
+		renditions[rendition.Name] = ProcessedImage{
+			Data:   buffer.Bytes(),
+			Width:  oriented.Bounds().Dx(),
+			Height: oriented.Bounds().Dy(),
+		}
+	}
+
+	return renditions, nil
+}
+
+// resizeImage scales the provided image down (never up) so that it fits within
+// a square of the provided size, and flattens any transparency onto white.
+func resizeImage(source image.Image, maxSize int) *image.RGBA {
+	width := source.Bounds().Dx()
+	height := source.Bounds().Dy()
+
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			height = max(1, (height * maxSize / width))
+			width = maxSize
+		} else {
+			width = max(1, (width * maxSize / height))
+			height = maxSize
+		}
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(resized, resized.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(resized, resized.Bounds(), source, source.Bounds(), xdraw.Over, nil)
+
+	return resized
+}
+
+// orientImage applies the provided EXIF orientation (1 through 8) to the
+// provided image, so that it is displayed the right way up without needing its
+// EXIF metadata.
+func orientImage(source *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return source
+	}
+
+	width := source.Bounds().Dx()
+	height := source.Bounds().Dy()
+
+	// Orientations 5 through 8 swap the width and height of the image
+	bounds := image.Rect(0, 0, width, height)
+	if orientation >= 5 {
+		bounds = image.Rect(0, 0, height, width)
+	}
+	oriented := image.NewRGBA(bounds)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = (width - 1 - x), y
+			case 3: // Rotated 180 degrees
+				dx, dy = (width - 1 - x), (height - 1 - y)
+			case 4: // Mirrored vertically
+				dx, dy = x, (height - 1 - y)
+			case 5: // Mirrored along the top-left to bottom-right diagonal
+				dx, dy = y, x
+			case 6: // Rotated 90 degrees clockwise
+				dx, dy = (height - 1 - y), x
+			case 7: // Mirrored along the top-right to bottom-left diagonal
+				dx, dy = (height - 1 - y), (width - 1 - x)
+			case 8: // Rotated 90 degrees counter-clockwise
+				dx, dy = y, (width - 1 - x)
+			}
+
+			oriented.SetRGBA(dx, dy, source.RGBAAt(x, y))
+		}
+	}
+
+	return oriented
+}
+
+// jpegOrientation returns the EXIF orientation (1 through 8) of the provided
+// JPEG data, or 1 if it doesn't have one (or isn't a JPEG at all).
+func jpegOrientation(data []byte) int {
+	if DetectImageType(data) != "image/jpeg" {
+		return 1
+	}
+
+	// Walk the JPEG's segments looking for the EXIF (APP1) segment
+	offset := 2
+	for (offset + 4) <= len(data) {
+		if data[offset] != 0xFF {
+			return 1
+		}
+
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[(offset + 2):]))
+		if marker == 0xDA || length < 2 || (offset+2+length) > len(data) {
+			// (NOTE: 0xDA is the start of the actual image data, after which
+			// there is no more metadata.)
+			return 1
+		}
+
+		segment := data[(offset + 4):(offset + 2 + length)]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+
+		offset += (2 + length)
+	}
+
+	return 1
+}
+
+// exifOrientation returns the orientation tag from the provided TIFF-formatted
+// EXIF data, or 1 if it doesn't have one.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	// Figure out the byte order of the EXIF data
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	// Look through the entries of the first IFD for the orientation tag
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || (ifd+2) > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := (ifd + 2 + (i * 12))
+		if (entry + 12) > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[(entry + 8):]))
+		}
+	}
+
+	return 1
+}
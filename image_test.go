@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	testRed  = color.RGBA{255, 0, 0, 255}
+	testBlue = color.RGBA{0, 0, 255, 255}
+)
+
+// newTestImage returns an image of the provided size whose left half is red and
+// whose right half is blue.
+func newTestImage(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.SetRGBA(x, y, testRed)
+			} else {
+				img.SetRGBA(x, y, testBlue)
+			}
+		}
+	}
+
+	return img
+}
+
+// encodeTestJPEG encodes the provided image as a JPEG, with an EXIF segment
+// holding the provided orientation (in the provided byte order) unless it is 0.
+func encodeTestJPEG(t *testing.T, img image.Image, orientation int, order binary.ByteOrder) []byte {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	if orientation == 0 {
+		return data
+	}
+
+	// Build a TIFF header with a single IFD holding just the orientation tag
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112) // Orientation
+	order.PutUint16(tiff[12:], 3)      // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+	order.PutUint32(tiff[22:], 0) // No next IFD
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	// Put the EXIF segment straight after the start of image marker
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// isReddish and isBluish return whether the provided colour is closer to red
+// or blue respectively. (NOTE: JPEG is lossy, so colours are never exact.)
+func isReddish(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xA000 && b < 0x6000
+}
+
+func isBluish(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return b > 0xA000 && r < 0x6000
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	img := newTestImage(8, 8)
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			if got := jpegOrientation(encodeTestJPEG(t, img, orientation, order)); got != orientation {
+				t.Errorf("jpegOrientation returned %d for orientation %d (%v), expected %d", got, orientation, order, orientation)
+			}
+		}
+	}
+
+	if got := jpegOrientation(encodeTestJPEG(t, img, 0, nil)); got != 1 {
+		t.Errorf("jpegOrientation returned %d for a JPEG without EXIF, expected 1", got)
+	}
+
+	var buffer bytes.Buffer
+	png.Encode(&buffer, img)
+	if got := jpegOrientation(buffer.Bytes()); got != 1 {
+		t.Errorf("jpegOrientation returned %d for a PNG, expected 1", got)
+	}
+
+	data := encodeTestJPEG(t, img, 6, binary.BigEndian)
+	for _, length := range []int{3, 8, 20, 30} {
+		if got := jpegOrientation(data[:length]); got != 1 {
+			t.Errorf("jpegOrientation returned %d for a JPEG truncated to %d bytes, expected 1", got, length)
+		}
+	}
+}
+
+func TestProcessImageAppliesOrientation(t *testing.T) {
+	// (NOTE: The test image is red on the left and blue on the right, as it is
+	// stored. Each orientation says how it has to be transformed to be the
+	// right way up.)
+	tests := []struct {
+		orientation   int
+		width, height int
+		first, second func(color.Color) bool // The colours at the top left and bottom right
+	}{
+		{1, 40, 20, isReddish, isBluish},
+		{2, 40, 20, isBluish, isReddish},
+		{3, 40, 20, isBluish, isReddish},
+		{4, 40, 20, isReddish, isBluish},
+		{5, 20, 40, isReddish, isBluish},
+		{6, 20, 40, isReddish, isBluish},
+		{7, 20, 40, isBluish, isReddish},
+		{8, 20, 40, isBluish, isReddish},
+	}
+
+	for _, test := range tests {
+		renditions, err := ProcessImage(encodeTestJPEG(t, newTestImage(40, 20), test.orientation, binary.BigEndian))
+		if err != nil {
+			t.Fatalf("ProcessImage returned an error for orientation %d: %v", test.orientation, err)
+		}
+
+		full := renditions["full"]
+		if full.Width != test.width || full.Height != test.height {
+			t.Errorf("orientation %d: got a %dx%d image, expected %dx%d", test.orientation, full.Width, full.Height, test.width, test.height)
+			continue
+		}
+
+		decoded, err := jpeg.Decode(bytes.NewReader(full.Data))
+		if err != nil {
+			t.Fatalf("orientation %d: ProcessImage returned an invalid JPEG: %v", test.orientation, err)
+		}
+		if bounds := decoded.Bounds(); bounds.Dx() != full.Width || bounds.Dy() != full.Height {
+			t.Errorf("orientation %d: the JPEG is %dx%d, but %dx%d was reported", test.orientation, bounds.Dx(), bounds.Dy(), full.Width, full.Height)
+		}
+		if !test.first(decoded.At(2, 2)) || !test.second(decoded.At(full.Width-3, full.Height-3)) {
+			t.Errorf("orientation %d: the image wasn't turned the right way up (top left %v, bottom right %v)", test.orientation, decoded.At(2, 2), decoded.At(full.Width-3, full.Height-3))
+		}
+		if got := jpegOrientation(full.Data); got != 1 {
+			t.Errorf("orientation %d: the EXIF orientation was kept", test.orientation)
+		}
+	}
+}
+
+func TestProcessImageRenditionSizes(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		sizes         map[string][2]int
+	}{
+		{"landscape", 2000, 1000, map[string][2]int{"thumbnail": {160, 80}, "card": {640, 320}, "full": {1600, 800}}},
+		{"portrait", 600, 1800, map[string][2]int{"thumbnail": {53, 160}, "card": {213, 640}, "full": {533, 1600}}},
+		{"small", 100, 50, map[string][2]int{"thumbnail": {100, 50}, "card": {100, 50}, "full": {100, 50}}},
+		{"thin", 1700, 1, map[string][2]int{"thumbnail": {160, 1}, "card": {640, 1}, "full": {1600, 1}}},
+	}
+
+	for _, test := range tests {
+		renditions, err := ProcessImage(encodeTestJPEG(t, newTestImage(test.width, test.height), 0, nil))
+		if err != nil {
+			t.Fatalf("%s: ProcessImage returned an error: %v", test.name, err)
+		}
+		if len(renditions) != len(gImageRenditions) {
+			t.Errorf("%s: got %d renditions, expected %d", test.name, len(renditions), len(gImageRenditions))
+		}
+
+		for name, size := range test.sizes {
+			rendition := renditions[name]
+			if rendition.Width != size[0] || rendition.Height != size[1] {
+				t.Errorf("%s: the %s rendition is %dx%d, expected %dx%d", test.name, name, rendition.Width, rendition.Height, size[0], size[1])
+			}
+		}
+	}
+}
+
+func TestProcessImageRejectsBadImages(t *testing.T) {
+	if _, err := ProcessImage([]byte("not an image at all")); err == nil {
+		t.Error("ProcessImage accepted data that isn't an image")
+	}
+
+	data := encodeTestJPEG(t, newTestImage(40, 20), 0, nil)
+	if _, err := ProcessImage(data[:len(data)/2]); err == nil {
+		t.Error("ProcessImage accepted a truncated JPEG")
+	}
+
+	oldMaxImagePixels := gMaxImagePixels
+	gMaxImagePixels = 40*20 - 1
+	defer func() { gMaxImagePixels = oldMaxImagePixels }()
+
+	if _, err := ProcessImage(data); err == nil {
+		t.Error("ProcessImage accepted an image with too many pixels")
+	}
+}
@@ -1,9 +1,244 @@
 package main
 
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
 // Like is a struct representing a Like between from one User of AKTVE of
 // another.
 type Like struct {
-	ID      int `json:"id" bson:"id"`
-	LikerID int `json:"liker_id" bson:"liker_id"`
-	LikeeID int `json:"likee_id" bson:"likee_id"`
+	ID      int       `json:"id" bson:"id"`
+	LikerID int       `json:"liker_id" bson:"liker_id"`
+	LikeeID int       `json:"likee_id" bson:"likee_id"`
+	Feeling string    `json:"feeling" bson:"feeling"` // Either "like" or "superlike" (older Likes may not have this set at all)
+	Date    time.Time `json:"date" bson:"date"`       // When the Like was first given
+
+	SuperLikedAt *time.Time `json:"superliked_at,omitempty" bson:"superliked_at,omitempty"` // When the Like was upgraded to a super-like, if it was given as a Like first
+}
+
+// IsSuperLike returns whether the Like was given as a super-like.
+func (o *Like) IsSuperLike() bool {
+	return o.Feeling == "superlike"
+}
+
+// LikeQuota is a struct representing how many Likes a User of AKTVE has given
+// during a single daily like quota period. (NOTE: This is only ever added to,
+// so withdrawing or disliking a Like never gives back any of the quota.)
+type LikeQuota struct {
+	ID         string    `bson:"_id"` // "<user ID>:<start of the period>"
+	UserID     int       `bson:"user_id"`
+	Start      time.Time `bson:"start"`
+	Likes      int       `bson:"likes"`
+	SuperLikes int       `bson:"superlikes"`
+	Expires    time.Time `bson:"expires"` // When the database can remove this
+}
+
+// likeQuotaID returns the ID of the LikeQuota of the User with the provided ID
+// for the daily like quota period containing the provided time.
+func likeQuotaID(userID int, now time.Time) string {
+	return strconv.Itoa(userID) + ":" + LikeQuotaStart(now).Format("2006-01-02")
+}
+
+// GetLikesGiven returns the number of Likes and super-likes that the User with
+// the provided ID has given during the daily like quota period containing the
+// provided time. Each feeling has its own quota, so they are counted
+// separately.
+func GetLikesGiven(likerID int, now time.Time) (int, int, error) {
+	var quota LikeQuota
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("like_quotas")
+	done := TimeDatabase("like_quotas", "find")
+	err := c.FindId(likeQuotaID(likerID, now)).One(&quota)
+	done()
+	if err != nil && err != mgo.ErrNotFound {
+		return 0, 0, errors.New("failed to retrieve like quota")
+	}
+
+	return quota.Likes, quota.SuperLikes, nil
+}
+
+// errLikeQuotaReached is returned when a User has already used up their daily
+// quota of the feeling that they are trying to give.
+var errLikeQuotaReached = errors.New("like: daily like quota reached")
+
+// likeQuotaField returns the LikeQuota field that Likes with the provided
+// feeling (either "like" or "superlike") are counted in.
+func likeQuotaField(feeling string) string {
+	if feeling == "superlike" {
+		return "superlikes"
+	}
+
+	return "likes"
+}
+
+// ClaimLikeGiven adds a Like with the provided feeling (either "like" or
+// "superlike") to the daily like quota of the User with the provided ID, but
+// only if fewer than the provided number of them have been given so far, and
+// returns the number of Likes and super-likes given including it. If the quota
+// has already been reached, errLikeQuotaReached is returned instead. (NOTE:
+// The quota is checked and added to in a single step, so that Likes sent at
+// the same time can't go over it.)
+func ClaimLikeGiven(likerID int, feeling string, quota int, now time.Time) (int, int, error) {
+	if quota <= 0 {
+		return 0, 0, errLikeQuotaReached
+	}
+
+	var claimed LikeQuota
+
+	field := likeQuotaField(feeling)
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	// (NOTE: If the quota has been reached, the query doesn't match, so the
+	// upsert tries to insert a second LikeQuota with the same ID and fails.)
+	c := db.DB(dbDB).C("like_quotas")
+	change := mgo.Change{
+		Update: bson.M{
+			"$inc": bson.M{field: 1},
+			"$setOnInsert": bson.M{
+				"user_id": likerID,
+				"start":   LikeQuotaStart(now),
+				"expires": LikeQuotaReset(now).Add(24 * time.Hour),
+			},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	done := TimeDatabase("like_quotas", "upsert")
+	_, err := c.Find(bson.M{"_id": likeQuotaID(likerID, now), field: bson.M{"$lt": quota}}).Apply(change, &claimed)
+	done()
+	if mgo.IsDup(err) {
+		return 0, 0, errLikeQuotaReached
+	} else if err != nil {
+		return 0, 0, errors.New("failed to record like in quota")
+	}
+
+	return claimed.Likes, claimed.SuperLikes, nil
+}
+
+// ReturnLikeGiven gives back a Like with the provided feeling that was claimed
+// from the daily like quota of the User with the provided ID, for when the Like
+// wasn't given after all (e.g. because it failed to be stored).
+func ReturnLikeGiven(likerID int, feeling string, now time.Time) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("like_quotas")
+	if err := c.UpdateId(likeQuotaID(likerID, now), bson.M{"$inc": bson.M{likeQuotaField(feeling): -1}}); err != nil && err != mgo.ErrNotFound {
+		return errors.New("failed to return like to quota")
+	}
+
+	return nil
+}
+
+// LikesRemaining returns how many Likes are left in a daily quota of the
+// provided size once the provided number of Likes have been given.
+func LikesRemaining(quota int, given int) int {
+	if given >= quota {
+		return 0
+	}
+
+	return quota - given
+}
+
+// LikeQuotaStart returns the time that the daily like quota period containing
+// the provided time started at. Quotas are reset at midnight UTC.
+func LikeQuotaStart(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour)
+}
+
+// LikeQuotaReset returns the time that the daily like quota period containing
+// the provided time will be reset at.
+func LikeQuotaReset(now time.Time) time.Time {
+	return LikeQuotaStart(now).Add(24 * time.Hour)
+}
+
+// RemoveDuplicateLikes removes any Likes that share the same liker and likee as
+// an earlier Like, so that the pair can be uniquely indexed.
+func RemoveDuplicateLikes() error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+
+	// Group the Likes by their liker and likee, keeping only the groups that
+	// have more than one Like in them
+	var groups []struct {
+		IDs []bson.ObjectId `bson:"ids"`
+	}
+	pipeline := []bson.M{
+		{"$sort": bson.M{"id": 1}},
+		{"$group": bson.M{
+			"_id":   bson.M{"liker_id": "$liker_id", "likee_id": "$likee_id"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}
+	if err := c.Pipe(pipeline).All(&groups); err != nil {
+		return errors.New("failed to find duplicate Likes")
+	}
+
+	// Remove everything but the first Like in each group
+	for _, group := range groups {
+		if _, err := c.RemoveAll(bson.M{"_id": bson.M{"$in": group.IDs[1:]}}); err != nil {
+			return errors.New("failed to remove duplicate Likes")
+		}
+	}
+
+	return nil
+}
+
+// RenumberDuplicateLikes gives a new, unique ID to any Like that shares its ID
+// with an earlier Like, so that Like IDs can be uniquely indexed. (NOTE: Like
+// IDs used to be allocated by counting the Likes, so deleted Likes caused IDs
+// to be reused.)
+func RenumberDuplicateLikes() error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+
+	// Group the Likes by their ID, keeping only the groups that have more than
+	// one Like in them
+	var groups []struct {
+		IDs []bson.ObjectId `bson:"ids"`
+	}
+	pipeline := []bson.M{
+		{"$sort": bson.M{"date": 1}},
+		{"$group": bson.M{
+			"_id":   "$id",
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}
+	if err := c.Pipe(pipeline).All(&groups); err != nil {
+		return errors.New("failed to find duplicate Like IDs")
+	}
+
+	// Give everything but the first Like in each group a new ID
+	for _, group := range groups {
+		for _, element := range group.IDs[1:] {
+			id, err := NextID("likes")
+			if err != nil {
+				return err
+			}
+
+			if err := c.UpdateId(element, bson.M{"$set": bson.M{"id": id}}); err != nil {
+				return errors.New("failed to renumber duplicate Like")
+			}
+		}
+	}
+
+	return nil
 }
@@ -1,26 +1,117 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 )
 
+// contextKey is the type of the keys that the API server stores values in
+// request contexts under.
+type contextKey string
+
+const requestIDContextKey contextKey = "request_id"
+
+// (NOTE: Request IDs from clients are only trusted if they look like this, so
+// that nobody can inject anything odd into the logs.)
+var gRequestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// These query string values are secrets or personal information, so they are
+// never logged.
+var gRedactedParams = []string{"token", "email_token", "fb_access_token", "apple_id_token", "google_id_token", "password", "email", "name", "signature"}
+
+// NewLogger creates a new structured logger that writes JSON lines to
+// standard error at the provided level ("debug", "info", "warn" or "error").
+func NewLogger(level string) *slog.Logger {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// RequestID returns the ID of the provided request, as assigned by Logger.
+func RequestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDContextKey).(string); ok {
+		return id
+	}
+
+	return ""
+}
+
+// RequestLogger returns a logger that tags everything it logs with the ID of
+// the provided request, so that application logs can be tied to access logs.
+func RequestLogger(r *http.Request) *slog.Logger {
+	return slog.Default().With("request_id", RequestID(r))
+}
+
+// RedactURL returns the path and query string of the provided URL with any
+// secrets or personal information in the query string redacted.
+func RedactURL(u *url.URL) string {
+	query := u.Query()
+	for key := range query {
+		for _, redacted := range gRedactedParams {
+			if strings.EqualFold(key, redacted) {
+				query[key] = []string{"REDACTED"}
+			}
+		}
+	}
+
+	if len(query) == 0 {
+		return u.Path
+	}
+
+	return u.Path + "?" + query.Encode()
+}
+
 // Logger spins off a new endpoint handler, as specified, and then keeps track
-// of and outputs details about its execution.
-// TODO: This would be a good place to introduce concurrency.
+// of and outputs details about its execution. Every request is given an ID
+// (or keeps the one that the client sent in X-Request-ID), which is sent back
+// in the response and attached to everything logged about the request.
 func Logger(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		inner.ServeHTTP(w, r)
+		// Work out the request's ID and attach it to the request and response
+		requestID := r.Header.Get("X-Request-ID")
+		if !gRequestIDPattern.MatchString(requestID) {
+			requestID = GenerateToken()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey, requestID))
+
+		recorder := &ResponseRecorder{ResponseWriter: w}
+
+		inner.ServeHTTP(recorder, r)
+
+		status := recorder.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		attributes := []any{
+			"request_id", requestID,
+			"method", r.Method,
+			"path", RedactURL(r.URL),
+			"route", name,
+			"status", status,
+			"size", recorder.Size,
+			"duration", time.Since(start).Seconds(),
+			"remote_addr", r.RemoteAddr,
+		}
+
+		// (NOTE: Only the session cache is checked for the User, so that
+		// logging never causes a trip to the database.)
+		if userID, ok := gSessionCache.CachedUserID(r.URL.Query().Get("token")); ok {
+			attributes = append(attributes, "user_id", userID)
+		}
 
-		log.Printf(
-			"%s\t%s\t%s\t%s",
-			r.Method,
-			r.RequestURI,
-			name,
-			time.Since(start),
-		)
+		slog.Info("request", attributes...)
 	})
 }
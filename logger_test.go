@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRedactURLRedactsFileURLSignature(t *testing.T) {
+	fileID := bson.NewObjectId()
+	signed := SignFileURL(FileURL(fileID), 42)
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("SignFileURL returned an invalid URL %q: %v", signed, err)
+	}
+	signature := u.Query().Get("signature")
+	if signature == "" {
+		t.Fatalf("SignFileURL returned %q, which has no signature", signed)
+	}
+
+	redacted := RedactURL(u)
+	if strings.Contains(redacted, signature) {
+		t.Errorf("RedactURL returned %q, which still contains the signature", redacted)
+	}
+	if query, err := url.ParseQuery(strings.SplitN(redacted, "?", 2)[1]); err != nil || query.Get("signature") != "REDACTED" {
+		t.Errorf("RedactURL returned %q, expected the signature to be REDACTED", redacted)
+	}
+	if !strings.HasPrefix(redacted, "/file/"+fileID.Hex()+"?") {
+		t.Errorf("RedactURL returned %q, expected the path to be kept", redacted)
+	}
+}
+
+func TestRedactURLKeepsOtherParams(t *testing.T) {
+	u, _ := url.Parse("/users?limit=10&token=secret&email=someone%40example.com")
+
+	redacted := RedactURL(u)
+	if strings.Contains(redacted, "secret") || strings.Contains(redacted, "example.com") {
+		t.Errorf("RedactURL returned %q, which still contains a secret", redacted)
+	}
+	if !strings.Contains(redacted, "limit=10") {
+		t.Errorf("RedactURL returned %q, expected limit to be kept", redacted)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"regexp"
+	"strings"
+)
+
+// Mailer is an interface for anything that can send emails to Users of AKTVE.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+var gMailer Mailer = &LogMailer{}
+
+// NewMailer creates a new Mailer based on the provided SMTP server address. If
+// no address is provided, emails are only written to the log (which is useful
+// for local testing, but nothing else).
+func NewMailer(address string) Mailer {
+	if address == "" {
+		return &LogMailer{Full: gMailLogFull}
+	}
+
+	return &SMTPMailer{Address: address, Username: gSMTPUsername, Password: gSMTPPassword, From: gMailFrom}
+}
+
+// LogMailer is a Mailer that writes emails to the log instead of sending them.
+// (NOTE: Emails contain tokens that log Users in or reset their password, so
+// the recipient and any query strings in links are redacted, unless Full is
+// set, in which case the whole email is logged at debug level only.)
+type LogMailer struct {
+	Full bool
+}
+
+// gMailQueryPattern matches the query string of any link in an email.
+var gMailQueryPattern = regexp.MustCompile(`\?\S*`)
+
+// Send writes the provided email to the log.
+func (o *LogMailer) Send(to string, subject string, body string) error {
+	if o.Full {
+		slog.Debug("mail: no SMTP server is configured, so the email was logged instead of sent", "to", to, "subject", subject, "body", body)
+
+		return nil
+	}
+
+	slog.Info("mail: no SMTP server is configured, so the email was logged instead of sent", "to", RedactEmail(to), "subject", subject, "body", gMailQueryPattern.ReplaceAllString(body, "?[redacted]"))
+
+	return nil
+}
+
+// RedactEmail returns the provided email address with everything but the first
+// character of its local part hidden (e.g. "j***@example.com").
+func RedactEmail(email string) string {
+	local, domain, found := strings.Cut(email, "@")
+	if !found || local == "" {
+		return "[redacted]"
+	}
+
+	return local[:1] + "***@" + domain
+}
+
+// SMTPMailer is a Mailer that sends emails through an SMTP server.
+type SMTPMailer struct {
+	Address  string // The "host:port" of the SMTP server
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends the provided email to the provided address.
+func (o *SMTPMailer) Send(to string, subject string, body string) error {
+	// Make sure nobody can sneak extra headers into the email
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return errors.New("mail: invalid recipient or subject")
+	}
+
+	var auth smtp.Auth
+	if o.Username != "" {
+		host, _, err := net.SplitHostPort(o.Address)
+		if err != nil {
+			return errors.New("mail: invalid SMTP server address")
+		}
+
+		auth = smtp.PlainAuth("", o.Username, o.Password, host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s", o.From, to, subject, body)
+	if err := smtp.SendMail(o.Address, auth, o.From, []string{to}, []byte(message)); err != nil {
+		return errors.New("mail: failed to send email")
+	}
+
+	return nil
+}
@@ -2,14 +2,78 @@ package main
 
 import (
 	"log"
+	"log/slog"
 	"net/http"
+	"time"
 )
 
 func main() {
-	// Connect to the database
+	// Log everything as structured JSON (NOTE: This includes anything logged
+	// through the standard "log" package.)
+	slog.SetDefault(NewLogger(gLogLevel))
+
+	// Connect to the database (waiting until it can be reached), and keep
+	// reconnecting to it whenever it stops being reachable (NOTE: Background
+	// workers are all stopped before the API server disconnects from the
+	// database when it shuts down.)
 	gDatabase.DatabaseConnect()
+	workers := NewWorkers()
+	workers.Start(func(stop <-chan struct{}) { gDatabase.DatabaseMonitor(gDatabaseCheckInterval, stop) })
+
+	// Set up the blob store that file contents are kept in
+	blobStore, err := NewBlobStore(gBlobStoreType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gBlobStore = blobStore
+
+	// Serve a fake Facebook Graph API for local testing, if asked to
+	if gFakeFacebookGraphAddress != "" {
+		slog.Warn("facebook: serving a fake Graph API, so anyone can log in with Facebook as anyone", "address", gFakeFacebookGraphAddress)
+		go func() {
+			log.Fatal(http.ListenAndServe(gFakeFacebookGraphAddress, &FakeGraphServer{AppID: gFacebookAppID, AppSecret: gFacebookAppSecret}))
+		}()
+		gFacebookClient = NewGraphFacebookClient("http://"+gFakeFacebookGraphAddress, gFacebookAppID, gFacebookAppSecret)
+	}
+
+	// Set up the ways that Users can log in, and the way that we email them
+	if err := RegisterIdentityProviders(); err != nil {
+		log.Fatal(err)
+	}
+	gMailer = NewMailer(gSMTPAddress)
+
+	// Periodically delete any accounts whose deletion grace period is over,
+	// and remove any files that are no longer referenced by anything
+	workers.Start(func(stop <-chan struct{}) { gUserCache.StartDeletionWorker(time.Hour, stop) })
+	workers.Start(func(stop <-chan struct{}) { StartFileGarbageCollector(6*time.Hour, stop) })
 
 	// Begin serving and routing API endpoints
 	router := NewRouter()
-	log.Fatal(http.ListenAndServe(":8080", router))
+	servers := []*http.Server{}
+	if gTLSCertFile != "" || gTLSKeyFile != "" {
+		// Serve over HTTPS, with the certificate reloaded whenever it changes
+		certificates, err := NewCertificateReloader(gTLSCertFile, gTLSKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certificates.Watch(gTLSReloadInterval)
+
+		server := NewServer(gHTTPSAddress, router)
+		server.TLSConfig = NewTLSConfig(certificates)
+		servers = append(servers, server)
+
+		// Redirect anyone who still uses plain HTTP to HTTPS
+		if gTLSRedirect {
+			servers = append(servers, NewServer(gHTTPAddress, http.HandlerFunc(RedirectToHTTPS)))
+		}
+	} else {
+		servers = append(servers, NewServer(gHTTPAddress, router))
+	}
+	for _, server := range servers {
+		slog.Info("Serving the API", "address", server.Addr, "tls", server.TLSConfig != nil)
+		Serve(server)
+	}
+
+	// Keep serving until asked to stop, and then stop gracefully
+	WaitForShutdown(gShutdownTimeout, workers, servers...)
 }
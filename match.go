@@ -19,10 +19,14 @@ func (o *Match) PullMessages() error {
 	// the database (NOTE: This suck. Do this better one day.)
 	o.Messages = o.Messages[:0]
 
+	db := gDatabase.Copy()
+	defer db.Close()
+
 	// Switch to the "messages" collection
-	c := gDatabase.db.DB(dbDB).C("messages")
+	c := db.DB(dbDB).C("messages")
 
 	// Retrieve all Messages from this Match
+	defer TimeDatabase("messages", "find")()
 	if err := c.Find(bson.M{"participants": bson.M{"$all": o.Participants}}).All(&o.Messages); err != nil {
 		return errors.New("failed to retrieve Messages")
 	}
@@ -35,14 +39,22 @@ func (o *Match) PutMessage(message Message) error {
 	// Append the Message to the local Match
 	o.Messages = append(o.Messages, message)
 
+	db := gDatabase.Copy()
+	defer db.Close()
+
 	// Switch to the "messages" collection
-	c := gDatabase.db.DB(dbDB).C("messages")
+	c := db.DB(dbDB).C("messages")
 
 	// Push the new Message up to the database
-	if err := c.Insert(&message); err != nil {
+	done := TimeDatabase("messages", "insert")
+	err := c.Insert(&message)
+	done()
+	if err != nil {
 		return errors.New("failed to push new Message up to database")
 	}
 
+	gMessages.Inc()
+
 	return nil
 }
 
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Declare the Prometheus metrics that the API server exposes at "/metrics"
+var (
+	gHTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aktve_http_requests_total",
+		Help: "The number of HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	gHTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aktve_http_request_duration_seconds",
+		Help:    "How long HTTP requests took to handle, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	gDatabaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aktve_database_operation_duration_seconds",
+		Help:    "How long database operations took, by collection and operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"collection", "operation"})
+	gDatabaseUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "aktve_database_up",
+		Help: "Whether the database was reachable when it was last checked (1 if so, 0 if not).",
+	})
+	gCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aktve_cache_lookups_total",
+		Help: "The number of lookups in the local caches, by cache and result (\"hit\" or \"miss\").",
+	}, []string{"cache", "result"})
+	gRecoveredPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aktve_recovered_panics_total",
+		Help: "The number of panics recovered from while handling requests, by route.",
+	}, []string{"route"})
+	gSignups = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "aktve_signups_total",
+		Help: "The number of Users created.",
+	})
+	gLikes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aktve_likes_total",
+		Help: "The number of Likes given, by feeling.",
+	}, []string{"feeling"})
+	gMatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "aktve_matches_total",
+		Help: "The number of Matches made.",
+	})
+	gMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "aktve_messages_total",
+		Help: "The number of Messages sent.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(gHTTPRequests, gHTTPRequestDuration, gDatabaseDuration, gDatabaseUp, gCacheLookups, gRecoveredPanics, gSignups, gLikes, gMatches, gMessages)
+}
+
+// ResponseRecorder wraps an http.ResponseWriter so that the status code and
+// size of the response can be found out once it has been written.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	Status int
+	Size   int
+}
+
+// WriteHeader records the status code before writing it.
+func (o *ResponseRecorder) WriteHeader(status int) {
+	if o.Status == 0 {
+		o.Status = status
+	}
+
+	o.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the response body as it is written.
+func (o *ResponseRecorder) Write(data []byte) (int, error) {
+	if o.Status == 0 {
+		o.Status = http.StatusOK
+	}
+
+	size, err := o.ResponseWriter.Write(data)
+	o.Size += size
+
+	return size, err
+}
+
+// Flush sends any buffered data to the client, if the wrapped writer can.
+func (o *ResponseRecorder) Flush() {
+	if flusher, ok := o.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Instrument spins off a new endpoint handler, as specified, and records its
+// request count, status code and latency in the Prometheus metrics.
+func Instrument(inner http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder, ok := w.(*ResponseRecorder)
+		if !ok {
+			recorder = &ResponseRecorder{ResponseWriter: w}
+		}
+
+		inner.ServeHTTP(recorder, r)
+
+		status := recorder.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		gHTTPRequests.WithLabelValues(name, r.Method, strconv.Itoa(status)).Inc()
+		gHTTPRequestDuration.WithLabelValues(name, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// TimeDatabase starts timing a database operation on the provided collection,
+// and returns a function that records how long it took once called. (e.g.
+// "defer TimeDatabase("users", "find")()")
+func TimeDatabase(collection string, operation string) func() {
+	start := time.Now()
+
+	return func() {
+		gDatabaseDuration.WithLabelValues(collection, operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RecordCacheLookup records whether a lookup in the cache with the provided
+// name was a hit or a miss.
+func RecordCacheLookup(cache string, hit bool) {
+	if hit {
+		gCacheLookups.WithLabelValues(cache, "hit").Inc()
+	} else {
+		gCacheLookups.WithLabelValues(cache, "miss").Inc()
+	}
+}
+
+// EndpointGETMetrics handles the "GET /metrics" endpoint, which exposes the
+// Prometheus metrics. (NOTE: Prometheus has to send AKTVE_METRICS_TOKEN as a
+// bearer token, so that the metrics aren't public. If it isn't set, the
+// metrics can't be scraped at all.)
+func EndpointGETMetrics(w http.ResponseWriter, r *http.Request) {
+	if gMetricsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+gMetricsToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	gMetricsHandler.ServeHTTP(w, r)
+}
+
+var gMetricsHandler = promhttp.Handler()
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ImageClassifier is an interface for automatically pre-screening uploaded
+// images before they are reviewed by a moderator. Classify returns the
+// moderation state that the provided image (the full rendition of an upload,
+// along with its data) should start out in, and the reason for it.
+type ImageClassifier interface {
+	Classify(file File, data []byte) (string, string)
+}
+
+var gImageClassifier ImageClassifier = &LocalImageClassifier{
+	MinSize:        200,
+	MaxAspectRatio: 3.0,
+}
+
+// LocalImageClassifier is an ImageClassifier that only performs simple checks
+// that don't need any outside services: it rejects images that are too small,
+// that have extreme aspect ratios, or that are identical to images that have
+// been rejected before. Everything else is left pending for a moderator (or
+// approved straight away, if images are auto-approved).
+type LocalImageClassifier struct {
+	MinSize        int     // The minimum width and height of an image, in pixels
+	MaxAspectRatio float64 // The maximum ratio between the longest and shortest side of an image
+}
+
+// Classify returns the moderation state that the provided image should start
+// out in, and the reason for it.
+func (o *LocalImageClassifier) Classify(file File, data []byte) (string, string) {
+	if file.Width < o.MinSize || file.Height < o.MinSize {
+		return "rejected", "image is too small"
+	}
+
+	longest := float64(max(file.Width, file.Height))
+	shortest := float64(min(file.Width, file.Height))
+	if (longest / shortest) > o.MaxAspectRatio {
+		return "rejected", "image is too narrow"
+	}
+
+	if IsHashRejected(file.SHA256) {
+		return "rejected", "image has been rejected before"
+	}
+
+	if gModerationAutoApprove {
+		return "approved", ""
+	}
+
+	return "pending", ""
+}
+
+// IsHashRejected returns whether an image with the provided content hash has
+// been rejected by a moderator before.
+func IsHashRejected(hash string) bool {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("rejected_hashes")
+	if cnt, err := c.FindId(hash).Count(); err == nil && cnt > 0 {
+		return true
+	}
+
+	return false
+}
+
+// GetPendingFiles returns a page of the uploaded images that are waiting to be
+// reviewed by a moderator, oldest first, along with the total number of them.
+func GetPendingFiles(offset int, limit int) ([]File, int, error) {
+	files := []File{}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	query := c.Find(bson.M{"moderation": "pending"})
+
+	total, err := query.Count()
+	if err != nil {
+		return files, 0, errors.New("failed to count pending Files")
+	}
+	if err := query.Sort("_id").Skip(offset).Limit(limit).All(&files); err != nil {
+		return files, 0, errors.New("failed to retrieve pending Files")
+	}
+
+	return files, total, nil
+}
+
+// ModerateFile records a moderator's decision ("approved" or "rejected") on the
+// uploaded image with the provided ID. Rejected images' content hashes are
+// remembered, so that the same image is rejected automatically if it is ever
+// uploaded again.
+func ModerateFile(id bson.ObjectId, decision string, reason string) error {
+	if decision != "approved" && decision != "rejected" {
+		return errors.New("moderation: invalid decision")
+	}
+
+	var file File
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("files")
+	if err := c.FindId(id).One(&file); err != nil {
+		return errors.New("moderation: could not find File with provided ID")
+	}
+
+	change := bson.M{"$set": bson.M{"moderation": decision, "moderation_reason": reason}}
+	if err := c.UpdateId(id, change); err != nil {
+		return errors.New("moderation: failed to update File")
+	}
+
+	// Whether the File was approved or not may change whether its owners can
+	// be suggested to other Users
+	if err := UpdateOnboardingOf(file.OwnerIDs); err != nil {
+		return err
+	}
+
+	if decision == "rejected" && file.SHA256 != "" {
+		c = db.DB(dbDB).C("rejected_hashes")
+		if _, err := c.UpsertId(file.SHA256, bson.M{"$set": bson.M{"file_id": id, "reason": reason}}); err != nil {
+			return errors.New("moderation: failed to remember rejected File")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDTokenClaims is a struct representing the claims in an OpenID Connect ID
+// token that AKTVE cares about.
+type IDTokenClaims struct {
+	Issuer        string      `json:"iss"`
+	Audience      interface{} `json:"aud"` // Either a string or a list of strings
+	Subject       string      `json:"sub"`
+	Expires       int64       `json:"exp"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // Either a bool or a string (Apple sends "true")
+	Name          string      `json:"name"`
+	Picture       string      `json:"picture"`
+}
+
+// HasAudience returns whether the ID token was issued for the provided client
+// ID.
+func (o *IDTokenClaims) HasAudience(clientID string) bool {
+	switch audience := o.Audience.(type) {
+	case string:
+		return audience == clientID
+	case []interface{}:
+		for _, element := range audience {
+			if element == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// IsEmailVerified returns whether the provider has verified the email address
+// in the ID token.
+func (o *IDTokenClaims) IsEmailVerified() bool {
+	return o.EmailVerified == true || o.EmailVerified == "true"
+}
+
+// IDTokenVerifier verifies OpenID Connect ID tokens (signed with RS256) from a
+// single issuer, such as Apple or Google.
+type IDTokenVerifier struct {
+	mutex         sync.Mutex
+	Issuers       []string // The "iss" values that the issuer uses
+	KeysURL       string   // Where the issuer publishes its signing keys
+	ClientID      string   // The "aud" that ID tokens must be issued for
+	keys          map[string]*rsa.PublicKey
+	keysFetched   time.Time // When the keys were last fetched
+	keysAttempted time.Time // When the keys were last asked for, whether or not that worked
+}
+
+// Verify checks the signature, issuer, audience and expiry of the provided ID
+// token, and returns its claims if they are all valid.
+func (o *IDTokenVerifier) Verify(token string) (IDTokenClaims, error) {
+	var claims IDTokenClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("oidc: malformed ID token")
+	}
+
+	// Read the header to find out which key the token was signed with
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+	if header.Algorithm != "RS256" {
+		return claims, errors.New("oidc: unsupported ID token algorithm")
+	}
+
+	key, err := o.key(header.KeyID)
+	if err != nil {
+		return claims, err
+	}
+
+	// Check the signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("oidc: malformed ID token signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return claims, errors.New("oidc: invalid ID token signature")
+	}
+
+	// Check the claims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+
+	issuerValid := false
+	for _, element := range o.Issuers {
+		if claims.Issuer == element {
+			issuerValid = true
+		}
+	}
+	if !issuerValid {
+		return claims, errors.New("oidc: ID token has the wrong issuer")
+	}
+	if !claims.HasAudience(o.ClientID) {
+		return claims, errors.New("oidc: ID token was issued for a different client")
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return claims, errors.New("oidc: ID token has expired")
+	}
+	if claims.Subject == "" {
+		return claims, errors.New("oidc: ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+// key returns the issuer's public key with the provided ID. The issuer's keys
+// are cached for an hour, but are fetched again straight away if the key
+// isn't known (as the issuer may have rotated its keys). If they can't be
+// fetched, the cached keys keep being used until they can be.
+func (o *IDTokenVerifier) key(keyID string) (*rsa.PublicKey, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	key, known := o.keys[keyID]
+	if known && time.Since(o.keysFetched) < time.Hour {
+		return key, nil
+	}
+
+	// (NOTE: Don't let tokens with made up key IDs, or an outage at the
+	// issuer, make us hammer the issuer.)
+	if time.Since(o.keysAttempted) < time.Minute {
+		if known {
+			return key, nil
+		}
+
+		return nil, errors.New("oidc: unknown ID token signing key")
+	}
+	o.keysAttempted = time.Now()
+
+	keys, err := fetchJWKS(o.KeysURL)
+	if err != nil {
+		if known {
+			return key, nil
+		}
+
+		return nil, err
+	}
+	o.keys = keys
+	o.keysFetched = time.Now()
+
+	if key, ok := o.keys[keyID]; ok {
+		return key, nil
+	}
+
+	return nil, errors.New("oidc: unknown ID token signing key")
+}
+
+// fetchJWKS retrieves the RSA public keys in the JSON Web Key Set at the
+// provided URL, by key ID.
+func fetchJWKS(keysURL string) (map[string]*rsa.PublicKey, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(keysURL)
+	if err != nil {
+		return nil, errors.New("oidc: failed to fetch signing keys")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("oidc: failed to fetch signing keys")
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, errors.New("oidc: malformed signing keys")
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, element := range set.Keys {
+		if element.KeyType != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(element.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(element.E)
+		if err != nil {
+			continue
+		}
+
+		keys[element.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	return keys, nil
+}
+
+// decodeJWTSegment decodes the provided base64url-encoded JSON segment of a
+// JSON Web Token into the provided value.
+func decodeJWTSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("oidc: malformed ID token")
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return errors.New("oidc: malformed ID token")
+	}
+
+	return nil
+}
+
+// OIDCIdentityProvider is an IdentityProvider that identifies Users by an
+// OpenID Connect ID token (e.g. from Sign in with Apple or Google Sign-In).
+type OIDCIdentityProvider struct {
+	Name       string // The name of the provider, as used by "POST /login"
+	Credential string // The name of the credential that holds the ID token
+	Verifier   *IDTokenVerifier
+}
+
+// NewAppleIdentityProvider creates a new IdentityProvider for Sign in with
+// Apple, accepting ID tokens issued for the provided client (i.e. the app's
+// bundle ID).
+func NewAppleIdentityProvider(clientID string) *OIDCIdentityProvider {
+	return &OIDCIdentityProvider{
+		Name:       "apple",
+		Credential: "apple_id_token",
+		Verifier: &IDTokenVerifier{
+			Issuers:  []string{"https://appleid.apple.com"},
+			KeysURL:  "https://appleid.apple.com/auth/keys",
+			ClientID: clientID,
+		},
+	}
+}
+
+// NewGoogleIdentityProvider creates a new IdentityProvider for Google Sign-In,
+// accepting ID tokens issued for the provided OAuth client ID.
+func NewGoogleIdentityProvider(clientID string) *OIDCIdentityProvider {
+	return &OIDCIdentityProvider{
+		Name:       "google",
+		Credential: "google_id_token",
+		Verifier: &IDTokenVerifier{
+			Issuers:  []string{"https://accounts.google.com", "accounts.google.com"},
+			KeysURL:  "https://www.googleapis.com/oauth2/v3/certs",
+			ClientID: clientID,
+		},
+	}
+}
+
+// Authenticate identifies a User by their ID token.
+func (o *OIDCIdentityProvider) Authenticate(credentials url.Values) (Identity, error) {
+	if credentials.Get(o.Credential) == "" {
+		return Identity{}, errors.New("identity: '" + o.Credential + "' is required")
+	}
+
+	claims, err := o.Verifier.Verify(credentials.Get(o.Credential))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Provider: o.Name, Subject: claims.Subject, Name: claims.Name}
+	if claims.IsEmailVerified() {
+		identity.Email = claims.Email
+	}
+	if claims.Picture != "" {
+		identity.ImageURLs = []string{claims.Picture}
+	}
+
+	// (NOTE: Apple only gives the User's name to the app, and only the first
+	// time that they sign in, so the app has to pass it along itself.)
+	if identity.Name == "" {
+		identity.Name = credentials.Get("name")
+	}
+
+	return identity, nil
+}
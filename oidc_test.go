@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeJWKSServer serves a JSON Web Key Set containing the provided key
+// (under the ID "test-key") until it is told to fail, and counts how many
+// times it has been asked for the keys.
+func newFakeJWKSServer(t *testing.T, key *rsa.PublicKey) (*httptest.Server, *atomic.Bool, *atomic.Int32) {
+	var failing atomic.Bool
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &failing, &requests
+}
+
+func TestIDTokenVerifierKeepsCachedKeysWhenFetchFails(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, failing, requests := newFakeJWKSServer(t, &private.PublicKey)
+	verifier := &IDTokenVerifier{KeysURL: server.URL}
+
+	if _, err := verifier.key("test-key"); err != nil {
+		t.Fatalf("key returned an error: %v", err)
+	}
+
+	// Let the cache go stale while the issuer is down
+	failing.Store(true)
+	verifier.keysFetched = time.Now().Add(-2 * time.Hour)
+	verifier.keysAttempted = verifier.keysFetched
+
+	key, err := verifier.key("test-key")
+	if err != nil {
+		t.Fatalf("key returned an error for a cached key while the issuer was down: %v", err)
+	}
+	if key.N.Cmp(private.PublicKey.N) != 0 {
+		t.Error("key returned a different key than the cached one")
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("expected the keys to be fetched again once they went stale, got %d fetches", requests.Load())
+	}
+
+	// Further logins during the outage shouldn't fetch the keys again
+	for i := 0; i < 5; i++ {
+		if _, err := verifier.key("test-key"); err != nil {
+			t.Fatalf("key returned an error for a cached key while the issuer was down: %v", err)
+		}
+	}
+	if _, err := verifier.key("unknown-key"); err == nil {
+		t.Error("key returned a key for an unknown key ID")
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected failed fetches to be throttled, got %d fetches", requests.Load())
+	}
+}
+
+func TestIDTokenVerifierFailsWithoutCachedKeys(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, failing, _ := newFakeJWKSServer(t, &private.PublicKey)
+	failing.Store(true)
+	verifier := &IDTokenVerifier{KeysURL: server.URL}
+
+	if _, err := verifier.key("test-key"); err == nil {
+		t.Error("key returned a key even though none could be fetched")
+	}
+}
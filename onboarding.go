@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The onboarding statuses that a User of AKTVE moves through as they set up
+// their account, in order. Only "active" Users are suggested to other Users.
+const (
+	OnboardingCreated         = "created"
+	OnboardingProfileComplete = "profile_complete"
+	OnboardingLocationSet     = "location_set"
+	OnboardingActive          = "active"
+)
+
+// OnboardingStep is a struct representing a single step of onboarding, along
+// with a function that returns what the User still has to do to complete it.
+type OnboardingStep struct {
+	Status  string
+	Missing func(o *User) []string
+}
+
+var gOnboardingSteps = []OnboardingStep{
+	{OnboardingProfileComplete, func(o *User) []string {
+		missing := []string{}
+		if o.Name == "" {
+			missing = append(missing, "name")
+		}
+		if o.Birthdate == nil {
+			missing = append(missing, "birthdate")
+		}
+		if len(o.Interests) == 0 {
+			missing = append(missing, "interests")
+		}
+		if len(o.Images) == 0 {
+			missing = append(missing, "images")
+		}
+
+		return missing
+	}},
+	{OnboardingLocationSet, func(o *User) []string {
+		// (NOTE: New Users start out at 0,0, which is in the middle of the
+		// ocean, so nobody is actually there.)
+		if o.Latitude == 0 && o.Longitude == 0 {
+			return []string{"location"}
+		}
+
+		return []string{}
+	}},
+	{OnboardingActive, func(o *User) []string {
+		if !o.HasApprovedImage() {
+			return []string{"approved_image"}
+		}
+
+		return []string{}
+	}},
+}
+
+// CheckOnboarding works out the User's onboarding status (i.e. the last step
+// that they have completed every step up to), along with everything that they
+// still have to do to finish onboarding.
+func (o *User) CheckOnboarding() (string, []string) {
+	status := OnboardingCreated
+	missing := []string{}
+
+	for _, step := range gOnboardingSteps {
+		stepMissing := step.Missing(o)
+		if len(stepMissing) == 0 && len(missing) == 0 {
+			status = step.Status
+		}
+
+		missing = append(missing, stepMissing...)
+	}
+
+	return status, missing
+}
+
+// UpdateOnboarding updates the User's onboarding status to reflect their
+// profile. This should be called before the User is pushed whenever their
+// profile has changed.
+func (o *User) UpdateOnboarding() {
+	o.Onboarding, _ = o.CheckOnboarding()
+}
+
+// IsOnboarded returns whether the User has completed at least the provided
+// onboarding step.
+func (o *User) IsOnboarded(status string) bool {
+	return onboardingRank(o.Onboarding) >= onboardingRank(status)
+}
+
+// onboardingRank returns how far through onboarding the provided status is
+// (where 0 is "created").
+func onboardingRank(status string) int {
+	for index, step := range gOnboardingSteps {
+		if step.Status == status {
+			return index + 1
+		}
+	}
+
+	return 0
+}
+
+// HasApprovedImage returns whether any of the User's images has been approved
+// by moderation (or never needed to be, e.g. pictures from Facebook).
+func (o *User) HasApprovedImage() bool {
+	if len(o.Images) == 0 {
+		return false
+	}
+
+	fileIDs := []bson.ObjectId{}
+	for _, element := range o.Images {
+		fileID, ok := FileIDFromURL(element)
+		if !ok {
+			return true
+		}
+
+		fileIDs = append(fileIDs, fileID)
+	}
+
+	unapproved, err := GetUnapprovedFileIDs(fileIDs)
+	if err != nil {
+		return false
+	}
+
+	return len(unapproved) < len(fileIDs)
+}
+
+// UpdateOnboardingOf updates the onboarding status of each of the Users with
+// the provided IDs (e.g. once an image that they use has been moderated).
+func UpdateOnboardingOf(userIDs []int) error {
+	for _, userID := range userIDs {
+		if _, userCacheIndex, err := gUserCache.GetUser(userID); err == nil {
+			gUserCache.Users[userCacheIndex].UpdateOnboarding()
+			if err := gUserCache.Users[userCacheIndex].Push(); err != nil {
+				return errors.New("failed to update user's onboarding status")
+			}
+		}
+	}
+
+	return nil
+}
+
+// MigrateOnboarding works out the onboarding status of any Users who were
+// created before there was onboarding, or who have no birthdate but have
+// somehow got further than "created". (NOTE: Users without a birthdate have no
+// age, so they can't be suggested to anyone. This sends them back through
+// onboarding, which asks them for their birthdate. It doesn't go through the
+// UserCache, so that it doesn't fill the cache with every User.)
+func MigrateOnboarding() error {
+	var user User
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("users")
+	query := bson.M{"$or": []bson.M{
+		{"onboarding": bson.M{"$exists": false}},
+		{"birthdate": nil, "onboarding": bson.M{"$ne": OnboardingCreated}},
+	}}
+	iter := c.Find(query).Iter()
+	for iter.Next(&user) {
+		user.UpdateOnboarding()
+		if err := c.Update(bson.M{"id": user.ID}, bson.M{"$set": bson.M{"onboarding": user.Onboarding}}); err != nil {
+			iter.Close()
+			return errors.New("failed to update user's onboarding status")
+		}
+
+		user = User{}
+	}
+	if err := iter.Close(); err != nil {
+		return errors.New("failed to retrieve users whose onboarding status needs working out")
+	}
+
+	return nil
+}
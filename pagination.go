@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Pagination is a model used to represent which portion of a list an API call
+// is returning.
+type Pagination struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"`
+}
+
+// NewPagination creates a new Pagination model from the "offset" and "limit"
+// query string values of the provided request, falling back to the first page
+// of a sensible size if they are missing or invalid.
+func NewPagination(r *http.Request) Pagination {
+	pagination := Pagination{Offset: 0, Limit: 20}
+
+	if num, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && num > 0 {
+		pagination.Offset = num
+	}
+	if num, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && num > 0 {
+		pagination.Limit = num
+	}
+	if pagination.Limit > 100 {
+		pagination.Limit = 100
+	}
+
+	return pagination
+}
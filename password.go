@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// errEmailNotVerified is returned when logging in with a password before the
+// email address it was registered with has been verified.
+var errEmailNotVerified = errors.New("password: email address is not verified")
+
+// (NOTE: Passwords are compared against this when there is no account for the
+// provided email address, so that how long a login takes doesn't give away
+// which email addresses have accounts.)
+var gDummyPasswordHash []byte
+var gDummyPasswordHashOnce sync.Once
+
+// ValidatePassword returns an error if the provided password isn't acceptable
+// for an account.
+func ValidatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password: must be at least 8 characters long")
+	}
+	if len(password) > 72 { // (NOTE: bcrypt ignores anything after 72 bytes.)
+		return errors.New("password: must be at most 72 bytes long")
+	}
+
+	return nil
+}
+
+// HashPassword returns the bcrypt hash of the provided password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), gBcryptCost)
+	if err != nil {
+		return "", errors.New("password: failed to hash password")
+	}
+
+	return string(hash), nil
+}
+
+// RegisterWithPassword creates a new User who logs in with the provided email
+// address and password, and emails them a link to verify their email address.
+// They can't log in until they have followed it. The User's birthdate is
+// optional at this point (it can be entered later). The new User's ID is
+// returned.
+func RegisterWithPassword(email string, password string, name string, birthdate *time.Time) (int, error) {
+	email = NormaliseEmail(email)
+
+	if err := ValidatePassword(password); err != nil {
+		return -1, err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return -1, err
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if count, err := c.Find(bson.M{"provider": "password", "subject": email}).Count(); err != nil {
+		return -1, errors.New("failed to retrieve identity links")
+	} else if count > 0 {
+		return -1, errors.New("email address is already registered")
+	}
+
+	// Create the new User, and link the email address and password to them
+	user, err := CreateUser(Identity{Provider: "password", Name: name, Birthdate: birthdate})
+	if err != nil {
+		return -1, err
+	}
+
+	link := IdentityLink{UserID: user.ID, Provider: "password", Subject: email, Email: email, Date: time.Now(), PasswordHash: hash}
+	if err := c.Insert(link); err != nil {
+		// (NOTE: This will happen if the same email address was registered
+		// twice at once, so don't leave the second User behind.)
+		db.DB(dbDB).C("users").Remove(bson.M{"id": user.ID})
+
+		if mgo.IsDup(err) {
+			return -1, errors.New("email address is already registered")
+		}
+
+		return -1, errors.New("failed to link identity")
+	}
+
+	// (NOTE: The User can always ask for another verification email, so don't
+	// fail the registration over it.)
+	_ = SendEmailVerification(email)
+
+	return user.ID, nil
+}
+
+// LoginWithPassword returns the ID of the User who logs in with the provided
+// email address and password. After too many failed attempts, the account is
+// locked for a while, and the time that it unlocks at is returned along with
+// an error. If the email address hasn't been verified yet, a new verification
+// link is sent to it, and errEmailNotVerified is returned.
+func LoginWithPassword(email string, password string) (int, time.Time, error) {
+	var link IdentityLink
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	query := bson.M{"provider": "password", "subject": NormaliseEmail(email)}
+	if err := c.Find(query).One(&link); err != nil {
+		gDummyPasswordHashOnce.Do(func() {
+			gDummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), gBcryptCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(gDummyPasswordHash, []byte(password))
+
+		return -1, time.Time{}, errors.New("password: invalid email address or password")
+	}
+
+	if link.LockedUntil != nil && time.Now().Before(*link.LockedUntil) {
+		return -1, *link.LockedUntil, errors.New("password: account is locked")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
+		// Count the failed attempt, and lock the account if there have been
+		// too many of them
+		change := mgo.Change{Update: bson.M{"$inc": bson.M{"failed_attempts": 1}}, ReturnNew: true}
+		if _, err := c.Find(query).Apply(change, &link); err == nil && link.FailedAttempts >= gPasswordMaxAttempts {
+			until := time.Now().Add(gPasswordLockoutDuration)
+			_ = c.Update(query, bson.M{"$set": bson.M{"locked_until": until}, "$unset": bson.M{"failed_attempts": ""}})
+
+			return -1, until, errors.New("password: account is locked")
+		}
+
+		return -1, time.Time{}, errors.New("password: invalid email address or password")
+	}
+
+	if link.FailedAttempts > 0 || link.LockedUntil != nil {
+		_ = c.Update(query, bson.M{"$unset": bson.M{"failed_attempts": "", "locked_until": ""}})
+	}
+
+	// (NOTE: This is only checked once the password is known to be right, so
+	// that it can't be used to find out who has an account.)
+	if !link.Verified {
+		_ = SendEmailVerification(link.Subject)
+
+		return -1, time.Time{}, errEmailNotVerified
+	}
+
+	if user, _, err := gUserCache.GetUser(link.UserID); err == nil && user.IsUnderage() {
+		return -1, time.Time{}, errUserTooYoung
+	}
+
+	return link.UserID, time.Time{}, nil
+}
+
+// SendEmailVerification emails a link to the provided address that the User
+// can use to prove that they own it.
+func SendEmailVerification(email string) error {
+	token, err := CreateEmailToken(email, "verify", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	link := gEmailVerifyURL + "?email_token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Tap the link below to verify your email address for AKTVE.\n\n%s\n\nIf you didn't sign up for AKTVE, you can safely ignore this email.", link)
+
+	return gMailer.Send(NormaliseEmail(email), "Verify your email address", body)
+}
+
+// VerifyEmail marks the email address that the provided verification token was
+// sent to as verified, and returns the ID of the User it belongs to.
+func VerifyEmail(token string) (int, error) {
+	email, err := ConsumeEmailToken(token, "verify")
+	if err != nil {
+		return -1, err
+	}
+
+	var link IdentityLink
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"verified": true}}, ReturnNew: true}
+	if _, err := c.Find(bson.M{"provider": "password", "subject": email}).Apply(change, &link); err != nil {
+		return -1, errors.New("failed to verify email address")
+	}
+
+	return link.UserID, nil
+}
+
+// SendPasswordReset emails a link to the provided address that the User can
+// use to choose a new password. (NOTE: Nothing is sent, but no error is
+// returned either, if there is no account for the address, so that this can't
+// be used to find out who has an account.)
+func SendPasswordReset(email string) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if count, err := c.Find(bson.M{"provider": "password", "subject": NormaliseEmail(email)}).Count(); err != nil {
+		return errors.New("failed to retrieve identity links")
+	} else if count == 0 {
+		return nil
+	}
+
+	token, err := CreateEmailToken(email, "reset", gEmailTokenLifetime)
+	if err != nil {
+		return err
+	}
+
+	link := gPasswordResetURL + "?email_token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Tap the link below to choose a new password for AKTVE. It can only be used once, and expires in %s.\n\n%s\n\nIf you didn't ask to reset your password, you can safely ignore this email.", gEmailTokenLifetime, link)
+
+	return gMailer.Send(NormaliseEmail(email), "Reset your password", body)
+}
+
+// ResetPassword sets a new password for the account that the provided reset
+// token was sent to. As the reset link was emailed, this also verifies the
+// email address, unlocks the account and logs the User out everywhere.
+func ResetPassword(token string, password string) error {
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+
+	email, err := ConsumeEmailToken(token, "reset")
+	if err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	var link IdentityLink
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	change := mgo.Change{Update: bson.M{
+		"$set":   bson.M{"password_hash": hash, "verified": true},
+		"$unset": bson.M{"failed_attempts": "", "locked_until": ""},
+	}}
+	if _, err := c.Find(bson.M{"provider": "password", "subject": email}).Apply(change, &link); err != nil {
+		return errors.New("failed to reset password")
+	}
+
+	return gSessionCache.CleanSessions(link.UserID)
+}
+
+// RevokeUnverifiedSessions logs out any Users whose only way of logging in is
+// a password for an email address that hasn't been verified yet. (NOTE: These
+// Sessions were created by registering, from before Users had to verify their
+// email address first.)
+func RevokeUnverifiedSessions() error {
+	var links []IdentityLink
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("identity_links")
+	if err := c.Find(bson.M{"provider": "password", "verified": bson.M{"$ne": true}}).All(&links); err != nil {
+		return errors.New("failed to retrieve unverified identity links")
+	}
+
+	for _, link := range links {
+		if count, err := c.Find(bson.M{"user_id": link.UserID}).Count(); err != nil {
+			return errors.New("failed to retrieve identity links")
+		} else if count == 1 {
+			gSessionCache.CleanSessions(link.UserID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recovery spins off a new endpoint handler, as specified, and recovers from
+// any panic within it, so that a single bad request can't take down the whole
+// API server. The panic is logged with its stack trace and the request's ID,
+// and the client is sent a 500 response (unless the handler had already
+// started responding, in which case there is nothing more that can be done).
+func Recovery(inner http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// (NOTE: This is how handlers are meant to abort a response on
+			// purpose, so let the HTTP server deal with it.)
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			gRecoveredPanics.WithLabelValues(name).Inc()
+			RequestLogger(r).Error("Recovered from a panic while handling a request", "route", name, "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+
+			// (NOTE: The client may already have been sent a success status,
+			// but the request still failed, so it is recorded as a 500 for the
+			// metrics and logs.)
+			if recorder, ok := w.(*ResponseRecorder); ok && recorder.Status != 0 {
+				recorder.Status = http.StatusInternalServerError
+				return
+			}
+
+			// Respond in the same shape as every other API call
+			returnData := struct {
+				Success Success
+			}{Success{Success: false, Error: "Internal API error. (Request ID: " + RequestID(r) + ")"}}
+
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(returnData)
+		}()
+
+		inner.ServeHTTP(w, r)
+	})
+}
@@ -15,6 +15,10 @@ func NewRouter() *mux.Router {
 		var handler http.Handler
 
 		handler = route.HandlerFunc
+		handler = Recovery(handler, route.Name)
+		handler = CircuitBreaker(handler, route.Name)
+		handler = Instrument(handler, route.Name)
+		handler = StrictTransportSecurity(handler, route.Name)
 		handler = Logger(handler, route.Name)
 
 		router.
@@ -27,6 +27,24 @@ var routes = Routes{
 		"/",
 		EndpointGETIndex,
 	},
+	Route{
+		"GETMetrics",
+		"GET",
+		"/metrics",
+		EndpointGETMetrics,
+	},
+	Route{
+		"GETHealthz",
+		"GET",
+		"/healthz",
+		EndpointGETHealthz,
+	},
+	Route{
+		"GETReadyz",
+		"GET",
+		"/readyz",
+		EndpointGETReadyz,
+	},
 	Route{
 		"GETStatus",
 		"GET",
@@ -39,6 +57,42 @@ var routes = Routes{
 		"/login",
 		EndpointPOSTLogin,
 	},
+	Route{
+		"POSTLoginEmail",
+		"POST",
+		"/login/email",
+		EndpointPOSTLoginEmail,
+	},
+	Route{
+		"POSTLoginPassword",
+		"POST",
+		"/login/password",
+		EndpointPOSTLoginPassword,
+	},
+	Route{
+		"POSTRegister",
+		"POST",
+		"/register",
+		EndpointPOSTRegister,
+	},
+	Route{
+		"POSTRegisterVerify",
+		"POST",
+		"/register/verify",
+		EndpointPOSTRegisterVerify,
+	},
+	Route{
+		"POSTPasswordReset",
+		"POST",
+		"/password/reset",
+		EndpointPOSTPasswordReset,
+	},
+	Route{
+		"POSTPasswordResetConfirm",
+		"POST",
+		"/password/reset/confirm",
+		EndpointPOSTPasswordResetConfirm,
+	},
 	Route{
 		"GETMeSettings",
 		"GET",
@@ -69,6 +123,42 @@ var routes = Routes{
 		"/me",
 		EndpointDELETEMe,
 	},
+	Route{
+		"DELETEMeDeletion",
+		"DELETE",
+		"/me/deletion",
+		EndpointDELETEMeDeletion,
+	},
+	Route{
+		"GETMeExport",
+		"GET",
+		"/me/export",
+		EndpointGETMeExport,
+	},
+	Route{
+		"GETMeOnboarding",
+		"GET",
+		"/me/onboarding",
+		EndpointGETMeOnboarding,
+	},
+	Route{
+		"GETMeIdentities",
+		"GET",
+		"/me/identities",
+		EndpointGETMeIdentities,
+	},
+	Route{
+		"POSTMeIdentities",
+		"POST",
+		"/me/identities",
+		EndpointPOSTMeIdentities,
+	},
+	Route{
+		"DELETEMeIdentitiesProvider",
+		"DELETE",
+		"/me/identities/{provider}",
+		EndpointDELETEMeIdentitiesProvider,
+	},
 	Route{
 		"GETMeMatches",
 		"GET",
@@ -105,12 +195,42 @@ var routes = Routes{
 		"/me/matches/{match_id}/messages/after/{message_id}",
 		EndpointGETMeMatchesIDMessagesAfterID,
 	},
+	Route{
+		"GETMeLikesReceived",
+		"GET",
+		"/me/likes/received",
+		EndpointGETMeLikesReceived,
+	},
+	Route{
+		"GETMeLikesSent",
+		"GET",
+		"/me/likes/sent",
+		EndpointGETMeLikesSent,
+	},
+	Route{
+		"DELETEMeLikesSentID",
+		"DELETE",
+		"/me/likes/sent/{user_id}",
+		EndpointDELETEMeLikesSentID,
+	},
+	Route{
+		"PUTMeImages",
+		"PUT",
+		"/me/images",
+		EndpointPUTMeImages,
+	},
 	Route{
 		"PUTMeImagesID",
 		"PUT",
 		"/me/images/{image_id}",
 		EndpointPUTMeImagesID,
 	},
+	Route{
+		"DELETEMeImagesID",
+		"DELETE",
+		"/me/images/{image_id}",
+		EndpointDELETEMeImagesID,
+	},
 	Route{
 		"GETUsersID",
 		"GET",
@@ -129,10 +249,28 @@ var routes = Routes{
 		"/potentials",
 		EndpointGETPotentials,
 	},
+	Route{
+		"GETAdminModeration",
+		"GET",
+		"/admin/moderation",
+		EndpointGETAdminModeration,
+	},
+	Route{
+		"PUTAdminModerationID",
+		"PUT",
+		"/admin/moderation/{file_id}",
+		EndpointPUTAdminModerationID,
+	},
 	Route{
 		"GETFileID",
 		"GET",
 		"/file/{file_id}",
 		EndpointGETFileID,
 	},
+	Route{
+		"HEADFileID",
+		"HEAD",
+		"/file/{file_id}",
+		EndpointGETFileID,
+	},
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// NewServer creates the HTTP server that serves the API on the provided
+// address, with timeouts so that slow or idle clients can't tie up
+// connections forever.
+func NewServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadTimeout:       gHTTPReadTimeout,
+		ReadHeaderTimeout: gHTTPReadHeaderTimeout,
+		WriteTimeout:      gHTTPWriteTimeout,
+		IdleTimeout:       gHTTPIdleTimeout,
+		MaxHeaderBytes:    gHTTPMaxHeaderBytes,
+	}
+}
+
+// Workers keeps track of the background workers (e.g. the deletion worker), so
+// that they can all be stopped when the API server shuts down.
+type Workers struct {
+	stop chan struct{}
+	wait sync.WaitGroup
+}
+
+// NewWorkers creates a new, empty set of background workers.
+func NewWorkers() *Workers {
+	return &Workers{stop: make(chan struct{})}
+}
+
+// Start runs the provided worker as a goroutine. The worker should return once
+// the channel it is given is closed.
+func (o *Workers) Start(worker func(stop <-chan struct{})) {
+	o.wait.Add(1)
+	go func() {
+		defer o.wait.Done()
+
+		worker(o.stop)
+	}()
+}
+
+// Stop asks every worker to stop, and waits until they all have (NOTE: A
+// worker that is in the middle of a run finishes it first.)
+func (o *Workers) Stop() {
+	close(o.stop)
+	o.wait.Wait()
+}
+
+// WaitForShutdown blocks until the API server is asked to stop (by SIGTERM,
+// e.g. from systemd, or SIGINT, e.g. from Ctrl+C), and then shuts it down
+// gracefully. The provided servers stop accepting new connections and wait
+// (for up to the provided timeout) for the requests in flight to finish. After
+// that, the provided workers are stopped, the caches are flushed to the
+// database and the database session is closed.
+func WaitForShutdown(timeout time.Duration, workers *Workers, servers ...*http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	received := <-signals
+	signal.Stop(signals)
+
+	slog.Info("Shutting down", "signal", received.String(), "timeout", timeout.String())
+
+	// Let the requests in flight finish (NOTE: Shutdown closes idle keep-alive
+	// connections straight away, and any others as soon as they become idle.)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wait sync.WaitGroup
+	for _, server := range servers {
+		wait.Add(1)
+		go func(server *http.Server) {
+			defer wait.Done()
+
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Error("Not every request finished before the shutdown timeout", "address", server.Addr, "error", err)
+				server.Close()
+			}
+		}(server)
+	}
+	wait.Wait()
+
+	// Stop the background workers, so that nothing else touches the database
+	workers.Stop()
+
+	// Make sure nothing in the caches is lost
+	if err := gUserCache.Flush(); err != nil {
+		slog.Error("There was an issue flushing the User cache", "error", err)
+	}
+
+	gDatabase.DatabaseDisconnect()
+
+	slog.Info("Shut down")
+}
+
+// Serve starts the provided server listening in the background, with TLS if
+// it has a TLS configuration. If it fails to start, the API server exits.
+func Serve(server *http.Server) {
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
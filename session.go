@@ -27,6 +27,8 @@ func (o *SessionCache) CheckSession(token string) (int, error) {
 	// Start by looking for the Session in the cache
 	for _, element := range o.Sessions {
 		if element.Token == token {
+			RecordCacheLookup("sessions", true)
+
 			// Update the associated User's last active time
 			_, userCacheIndex, _ := gUserCache.GetUser(element.UserID)
 			gUserCache.Users[userCacheIndex].LastActive = time.Now().String()
@@ -40,8 +42,16 @@ func (o *SessionCache) CheckSession(token string) (int, error) {
 	// it if it is found)
 	var session Session
 
-	c := gDatabase.db.DB(dbDB).C("sessions")
-	if err := c.Find(bson.M{"token": token}).One(&session); err != nil {
+	RecordCacheLookup("sessions", false)
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("sessions")
+	done := TimeDatabase("sessions", "find")
+	err := c.Find(bson.M{"token": token}).One(&session)
+	done()
+	if err != nil {
 		return -1, errors.New("could not find Session with provided Token")
 	}
 
@@ -55,6 +65,23 @@ func (o *SessionCache) CheckSession(token string) (int, error) {
 	return session.UserID, nil
 }
 
+// CachedUserID returns the ID of the User that the Session with the provided
+// Token belongs to, but only if the Session is already in the cache (i.e.
+// without going to the database or updating the User's last active time).
+func (o *SessionCache) CachedUserID(token string) (int, bool) {
+	if token == "" {
+		return -1, false
+	}
+
+	for _, element := range o.Sessions {
+		if element.Token == token {
+			return element.UserID, true
+		}
+	}
+
+	return -1, false
+}
+
 // CreateSession creates a new Session (and associated token) for the User with
 // the provided ID, removes any old Sessions for said User, adds the new
 // Session to the database and cache, and finally returns the new Session.
@@ -62,8 +89,11 @@ func (o *SessionCache) CreateSession(userID int) (Session, error) {
 	// Remove any old sessions for the user
 	o.CleanSessions(userID)
 
+	db := gDatabase.Copy()
+	defer db.Close()
+
 	// Switch to the sessions database collection
-	c := gDatabase.db.DB(dbDB).C("sessions")
+	c := db.DB(dbDB).C("sessions")
 
 	// Generate a new access token (and regenerate it until it is
 	// a unique one)
@@ -88,8 +118,11 @@ func (o *SessionCache) CreateSession(userID int) (Session, error) {
 // CleanSessions removes all sessions associated with the User with the
 // provided ID.
 func (o *SessionCache) CleanSessions(userID int) error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
 	// Switch to the sessions database collection
-	c := gDatabase.db.DB(dbDB).C("sessions")
+	c := db.DB(dbDB).C("sessions")
 
 	// Remove any old Sessions for the User from the database
 	c.RemoveAll(bson.M{"user_id": userID})
@@ -10,9 +10,11 @@ type Status struct {
     Status  string      `json:"status"`
     Version float32     `json:"version"`
     Time    time.Time   `json:"time"`
+    Build   BuildInfo   `json:"build"`
 }
 
 // Update will update the fields of the Status model it is operating on.
 func (o *Status) Update() {
     o.Time = time.Now()
+    o.Build = GetBuildInfo()
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// SwipeTracker keeps track of how quickly Users of AKTVE are swiping on one
+// another so that bot-like behaviour can be detected and temporarily
+// throttled.
+type SwipeTracker struct {
+	mutex      sync.Mutex
+	Swipes     map[int][]time.Time
+	Throttled  map[int]time.Time
+	lastPruned time.Time
+}
+
+var gSwipeTracker = SwipeTracker{
+	Swipes:    map[int][]time.Time{},
+	Throttled: map[int]time.Time{},
+}
+
+// RecordSwipe records a new swipe for the User with the provided ID. If the
+// User is swiping too quickly (or is still throttled from doing so earlier),
+// the swipe is rejected and the time that the throttle lifts is returned along
+// with an error.
+func (o *SwipeTracker) RecordSwipe(userID int) (time.Time, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	now := time.Now()
+
+	// Forget about any Users who have stopped swiping every so often, so that
+	// the tracker doesn't keep growing
+	if now.Sub(o.lastPruned) > gSwipeVelocityWindow {
+		o.prune(now)
+	}
+
+	// See if the User is still throttled
+	if until, ok := o.Throttled[userID]; ok {
+		if now.Before(until) {
+			return until, errors.New("swipe: User is throttled")
+		}
+
+		delete(o.Throttled, userID)
+	}
+
+	// Drop any swipes that have fallen out of the velocity window
+	swipes := o.Swipes[userID]
+	for len(swipes) > 0 && now.Sub(swipes[0]) > gSwipeVelocityWindow {
+		swipes = swipes[1:]
+	}
+	swipes = append(swipes, now)
+
+	// Throttle the User if they have swiped too many times within the window
+	if len(swipes) > gSwipeVelocityLimit {
+		until := now.Add(gSwipeThrottleDuration)
+		o.Throttled[userID] = until
+		delete(o.Swipes, userID)
+
+		return until, errors.New("swipe: User is swiping too quickly")
+	}
+
+	o.Swipes[userID] = swipes
+
+	return time.Time{}, nil
+}
+
+// prune removes every User whose swipes have all fallen out of the velocity
+// window, or whose throttle has lifted. (NOTE: The mutex must already be held
+// when this is called.)
+func (o *SwipeTracker) prune(now time.Time) {
+	for userID, swipes := range o.Swipes {
+		if len(swipes) == 0 || now.Sub(swipes[len(swipes)-1]) > gSwipeVelocityWindow {
+			delete(o.Swipes, userID)
+		}
+	}
+	for userID, until := range o.Throttled {
+		if !now.Before(until) {
+			delete(o.Throttled, userID)
+		}
+	}
+
+	o.lastPruned = now
+}
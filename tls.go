@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CertificateReloader keeps the TLS certificate that the API server serves
+// loaded from the provided files, and reloads it whenever they change (or the
+// API server is sent SIGHUP), so that renewed certificates are picked up
+// without a restart.
+type CertificateReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time // When the certificate or key file was last modified
+}
+
+// NewCertificateReloader creates a new CertificateReloader and loads the
+// certificate for the first time.
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	o := &CertificateReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := o.Reload(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// Reload loads the certificate from its files again. (NOTE: If the files are
+// invalid, e.g. because they are halfway through being replaced, the current
+// certificate is kept.)
+func (o *CertificateReloader) Reload() error {
+	modTime, err := o.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return errors.New("tls: failed to load certificate and key")
+	}
+
+	o.mutex.Lock()
+	o.certificate = &certificate
+	o.modTime = modTime
+	o.mutex.Unlock()
+
+	return nil
+}
+
+// filesModTime returns when the certificate or key file was last modified,
+// whichever was later.
+func (o *CertificateReloader) filesModTime() (time.Time, error) {
+	certInfo, err := os.Stat(o.CertFile)
+	if err != nil {
+		return time.Time{}, errors.New("tls: failed to read certificate file")
+	}
+	keyInfo, err := os.Stat(o.KeyFile)
+	if err != nil {
+		return time.Time{}, errors.New("tls: failed to read key file")
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}
+
+// GetCertificate returns the currently loaded certificate. It is meant to be
+// used as the GetCertificate function of a tls.Config.
+func (o *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.certificate, nil
+}
+
+// Watch reloads the certificate whenever the API server is sent SIGHUP, or
+// its files are found to have changed when checked at the provided interval.
+func (o *CertificateReloader) Watch(interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hangups:
+		case <-ticker.C:
+			o.mutex.RLock()
+			loaded := o.modTime
+			o.mutex.RUnlock()
+
+			if modTime, err := o.filesModTime(); err != nil || !modTime.After(loaded) {
+				continue
+			}
+		}
+
+		if err := o.Reload(); err != nil {
+			slog.Error("There was an issue reloading the TLS certificate", "error", err)
+		} else {
+			slog.Info("Reloaded the TLS certificate", "cert_file", o.CertFile)
+		}
+	}
+}
+
+// NewTLSConfig creates the TLS configuration that the API server is served
+// with, using the certificate kept by the provided CertificateReloader. Only
+// TLS 1.2 and up are allowed, with forward secret AEAD cipher suites.
+func NewTLSConfig(certificates *CertificateReloader) *tls.Config {
+	return &tls.Config{
+		GetCertificate: certificates.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+		// (NOTE: These only apply to TLS 1.2, as TLS 1.3's cipher suites
+		// aren't configurable, and are all fine.)
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// RedirectToHTTPS handles every request to the plain HTTP listener while TLS
+// is enabled, by redirecting it to the same path on the API's HTTPS URL.
+// (NOTE: The configured URL is used rather than the request's Host header, so
+// that this can't be used to redirect anywhere else.)
+func RedirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, HTTPSURL()+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// HTTPSURL returns the URL that the API is served over HTTPS at. Unless it is
+// configured, this is the API URL with the port that HTTPS is served on (as
+// the two only line up if HTTPS is served on port 443).
+func HTTPSURL() string {
+	if gTLSRedirectURL != "" {
+		return strings.TrimRight(gTLSRedirectURL, "/")
+	}
+
+	target, err := url.Parse(gAPIURL)
+	if err != nil {
+		return gAPIURL
+	}
+	if _, port, err := net.SplitHostPort(gHTTPSAddress); err == nil && port != "" && port != "443" {
+		target.Host = net.JoinHostPort(target.Hostname(), port)
+	}
+
+	return strings.TrimRight(target.String(), "/")
+}
+
+// StrictTransportSecurity spins off a new endpoint handler, as specified, and
+// tells clients that reached it over TLS to only ever use HTTPS for the API
+// from now on.
+func StrictTransportSecurity(inner http.Handler, name string) http.Handler {
+	header := "max-age=" + strconv.Itoa(int(gHSTSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && gHSTSMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", header)
+		}
+
+		inner.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	oldAPIURL, oldHTTPSAddress, oldRedirectURL := gAPIURL, gHTTPSAddress, gTLSRedirectURL
+	defer func() { gAPIURL, gHTTPSAddress, gTLSRedirectURL = oldAPIURL, oldHTTPSAddress, oldRedirectURL }()
+	gAPIURL = "https://api.aktve-app.com"
+
+	tests := []struct {
+		name         string
+		httpsAddress string
+		redirectURL  string
+		target       string
+		want         string
+	}{
+		{"port 443", ":443", "", "/status", "https://api.aktve-app.com/status"},
+		{"port 443 on all interfaces", "[::]:443", "", "/status", "https://api.aktve-app.com/status"},
+		{"other port", ":8443", "", "/status", "https://api.aktve-app.com:8443/status"},
+		{"other port on one interface", "10.0.0.1:9443", "", "/status", "https://api.aktve-app.com:9443/status"},
+		{"configured", ":8443", "https://aktve.example.com", "/status", "https://aktve.example.com/status"},
+		{"configured with trailing slash", ":8443", "https://aktve.example.com/", "/status", "https://aktve.example.com/status"},
+		{"query string", ":8443", "", "/users/1?token=abc&limit=10", "https://api.aktve-app.com:8443/users/1?token=abc&limit=10"},
+		{"query string configured", ":443", "https://aktve.example.com:4443", "/me?token=abc", "https://aktve.example.com:4443/me?token=abc"},
+		{"root", ":443", "", "/", "https://api.aktve-app.com/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gHTTPSAddress, gTLSRedirectURL = test.httpsAddress, test.redirectURL
+
+			req := httptest.NewRequest("GET", "http://evil.example.com"+test.target, nil)
+			res := httptest.NewRecorder()
+			RedirectToHTTPS(res, req)
+
+			if res.Code != http.StatusPermanentRedirect {
+				t.Errorf("RedirectToHTTPS responded with %d, expected %d", res.Code, http.StatusPermanentRedirect)
+			}
+			if location := res.Header().Get("Location"); location != test.want {
+				t.Errorf("RedirectToHTTPS redirected to %q, expected %q", location, test.want)
+			}
+		})
+	}
+}
@@ -11,7 +11,7 @@ systemctl disable aktveapisvr
 # Update the server
 su -s /bin/sh gitadmin-ta-aktveapiserver -c 'cd /opt/TA-AKTVEAPIServer && git pull && cd -'
 rsync -zvh /opt/TA-AKTVEAPIServer/aktveapisvr.service /etc/systemd/system/aktveapisvr.service
-export GOPATH=/opt/go && export GOBIN=$GOPATH/bin && export PATH=$PATH:/usr/local/go/bin:$GOBIN && cd /opt/TA-AKTVEAPIServer && go get && go install && go build -o /opt/TA-AKTVEAPIServer/ta-aktveapiserver && cd -
+export GOPATH=/opt/go && export GOBIN=$GOPATH/bin && export PATH=$PATH:/usr/local/go/bin:$GOBIN && cd /opt/TA-AKTVEAPIServer && go get && go install && go build -ldflags "-X main.gBuildCommit=$(git rev-parse HEAD) -X main.gBuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o /opt/TA-AKTVEAPIServer/ta-aktveapiserver && cd -
 
 # Restart the server
 systemctl enable aktveapisvr
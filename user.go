@@ -2,35 +2,49 @@ package main
 
 import (
 	"errors"
+	"log/slog"
 	"math"
+	"strconv"
+	"sync"
+	"time"
 
 	"gopkg.in/mgo.v2/bson"
 )
 
 // User is a struct representing a User of AKTVE.
 type User struct {
-	ID            int            `json:"id,omitempty" bson:"id"`
-	Name          string         `json:"name,omitempty" bson:"name"`
-	Age           int            `json:"age,omitempty" bson:"age"`
-	Interests     map[string]int `json:"interests,omitempty" bson:"interests"`
-	Tags          []string       `json:"tags,omitempty" bson:"tags"`
-	Bio           string         `json:"bio,omitempty" bson:"bio"`
-	Images        []string       `json:"images,omitempty" bson:"images"`
-	Matches       []Match        `json:"-" bson:"-"` // (NOTE: We don't want to return matches every time a user struct is returned.)
-	Latitude      float32        `json:"latitude,omitempty" bson:"latitude"`
-	Longitude     float32        `json:"longitude,omitempty" bson:"longitude"`
-	LastActive    string         `json:"last_active,omitempty" bson:"last_active"`
-	ShareLocation bool           `json:"share_location,omitempty" bson:"share_location"`
+	ID                int            `json:"id,omitempty" bson:"id"`
+	Name              string         `json:"name,omitempty" bson:"name"`
+	Age               int            `json:"age,omitempty" bson:"-"` // (NOTE: This is calculated from the User's birthdate; see UpdateAge.)
+	Interests         map[string]int `json:"interests,omitempty" bson:"interests"`
+	Tags              []string       `json:"tags,omitempty" bson:"tags"`
+	Bio               string         `json:"bio,omitempty" bson:"bio"`
+	Images            []string       `json:"images,omitempty" bson:"images"`
+	Matches           []Match        `json:"-" bson:"-"` // (NOTE: We don't want to return matches every time a user struct is returned.)
+	Latitude          float32        `json:"latitude,omitempty" bson:"latitude"`
+	Longitude         float32        `json:"longitude,omitempty" bson:"longitude"`
+	LastActive        string         `json:"last_active,omitempty" bson:"last_active"`
+	ShareLocation     bool           `json:"share_location,omitempty" bson:"share_location"`
+	DeletionDate      *time.Time     `json:"deletion_date,omitempty" bson:"deletion_date,omitempty"`
+	Admin             bool           `json:"-" bson:"admin,omitempty"` // (NOTE: Admins can only be made by hand in the database.)
+	Birthdate         *time.Time     `json:"birthdate,omitempty" bson:"birthdate,omitempty"`
+	BirthdateVerified bool           `json:"birthdate_verified,omitempty" bson:"birthdate_verified,omitempty"` // Whether the birthdate came from an identity provider rather than the User
+	Onboarding        string         `json:"onboarding,omitempty" bson:"onboarding,omitempty"`                 // How far through setting up their account the User is (see onboarding.go)
 }
 
 // UserCache is a local cache of User objects used to decrease the number of
 // back-and-forth trips between the database server and the API server.
 type UserCache struct {
 	Users []User
+	mutex sync.Mutex // Held while Users are added to or removed from the cache
 }
 
 var gUserCache UserCache
 
+// deletedUserID is the ID that deleted Users' Messages are attributed to, so
+// that the other participant keeps their side of the conversation.
+const deletedUserID = -1
+
 // GetMatch returns the Match with the given ID if the User has one.
 func (o *User) GetMatch(id int) (Match, error) {
 	for _, element := range o.Matches {
@@ -71,7 +85,12 @@ func (o *User) IsMatchedWith(userID int) bool {
 // CurrentlyLikes returns whether the User currently already likes the User with
 // the provided ID.
 func (o *User) CurrentlyLikes(userID int) bool {
-	c := gDatabase.db.DB(dbDB).C("likes")
+	defer TimeDatabase("likes", "count")()
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
 	if cnt, err := c.Find(bson.M{"liker_id": o.ID, "likee_id": userID}).Count(); err == nil && cnt > 0 {
 		return true
 	}
@@ -79,11 +98,369 @@ func (o *User) CurrentlyLikes(userID int) bool {
 	return false
 }
 
+// GetLikedUserIDs returns the IDs of all of the Users that the User currently
+// likes.
+func (o *User) GetLikedUserIDs() ([]int, error) {
+	var likes []Like
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+	if err := c.Find(bson.M{"liker_id": o.ID}).All(&likes); err != nil {
+		return nil, errors.New("failed to retrieve Likes")
+	}
+
+	ids := []int{}
+	for _, element := range likes {
+		ids = append(ids, element.LikeeID)
+	}
+
+	return ids, nil
+}
+
+// GetReceivedLikes returns a page of the Likes that the User has received from
+// Users that they have not (yet) liked back, newest first, along with the total
+// number of such Likes.
+func (o *User) GetReceivedLikes(offset int, limit int) ([]Like, int, error) {
+	likes := []Like{}
+
+	// Exclude Likes from Users that the User already likes back, as those are
+	// already Matches (TODO: Likes from Users that the User has blocked should
+	// be excluded too, but Users can't block each other yet. Add them to the
+	// excluded IDs here once they can.)
+	likedIDs, err := o.GetLikedUserIDs()
+	if err != nil {
+		return likes, 0, err
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+	query := c.Find(bson.M{"likee_id": o.ID, "liker_id": bson.M{"$nin": likedIDs}})
+
+	total, err := query.Count()
+	if err != nil {
+		return likes, 0, errors.New("failed to count received Likes")
+	}
+	if err := query.Sort("-date").Skip(offset).Limit(limit).All(&likes); err != nil {
+		return likes, 0, errors.New("failed to retrieve received Likes")
+	}
+
+	return likes, total, nil
+}
+
+// GetSentLikes returns a page of the Likes that the User has given to other
+// Users, newest first, along with the total number of Likes given.
+func (o *User) GetSentLikes(offset int, limit int) ([]Like, int, error) {
+	likes := []Like{}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+	query := c.Find(bson.M{"liker_id": o.ID})
+
+	total, err := query.Count()
+	if err != nil {
+		return likes, 0, errors.New("failed to count sent Likes")
+	}
+	if err := query.Sort("-date").Skip(offset).Limit(limit).All(&likes); err != nil {
+		return likes, 0, errors.New("failed to retrieve sent Likes")
+	}
+
+	return likes, total, nil
+}
+
+// WithdrawLike removes the User's Like of the User with the provided ID.
+func (o *User) WithdrawLike(userID int) error {
+	if !o.CurrentlyLikes(userID) {
+		return errors.New("could not find Like of User with provided ID")
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+	if _, err := c.RemoveAll(bson.M{"liker_id": o.ID, "likee_id": userID}); err != nil {
+		return errors.New("failed to remove Like")
+	}
+
+	return nil
+}
+
+// GetSuperLikerIDs returns the IDs of all of the Users that currently
+// super-like the User.
+func (o *User) GetSuperLikerIDs() ([]int, error) {
+	var likes []Like
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+	if err := c.Find(bson.M{"likee_id": o.ID, "feeling": "superlike"}).All(&likes); err != nil {
+		return nil, errors.New("failed to retrieve super-likes")
+	}
+
+	ids := []int{}
+	for _, element := range likes {
+		ids = append(ids, element.LikerID)
+	}
+
+	return ids, nil
+}
+
+// IsSuperLikedBy returns whether the User with the provided ID currently
+// super-likes the User.
+func (o *User) IsSuperLikedBy(userID int) bool {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("likes")
+	if cnt, err := c.Find(bson.M{"liker_id": userID, "likee_id": o.ID, "feeling": "superlike"}).Count(); err == nil && cnt > 0 {
+		return true
+	}
+
+	return false
+}
+
+// HasImage returns whether any of the User's images point to the File with the
+// provided ID.
+func (o *User) HasImage(fileID bson.ObjectId) bool {
+	for _, element := range o.Images {
+		if id, ok := FileIDFromURL(element); ok && id == fileID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OwnsFile returns whether the File with the provided ID belongs to the User.
+func (o *User) OwnsFile(fileID bson.ObjectId) bool {
+	if IsFileOwnedBy(fileID, o.ID) {
+		return true
+	}
+
+	// (NOTE: Files uploaded before ownership was tracked have no owners, so
+	// they belong to whoever has them among their images.)
+	return o.HasImage(fileID) && !IsFileOwned(fileID)
+}
+
+// ReorderImages rearranges the User's images into the order of the provided
+// File IDs (which must list every one of the User's uploaded images exactly
+// once), and then moves the image of the provided primary File ID (if any) to
+// the front. Images that aren't uploaded Files (e.g. Facebook profile
+// pictures) are kept after the rest. Nothing is changed unless the whole
+// request is valid. The User still needs to be pushed afterwards.
+func (o *User) ReorderImages(order []bson.ObjectId, primary bson.ObjectId) error {
+	// Split the User's images into uploaded Files and everything else
+	uploaded := map[bson.ObjectId]int{}
+	external := []string{}
+	for _, element := range o.Images {
+		if fileID, ok := FileIDFromURL(element); ok {
+			uploaded[fileID]++
+		} else {
+			external = append(external, element)
+		}
+	}
+
+	// Put the uploaded Files into the requested order
+	images := []string{}
+	if len(order) > 0 {
+		if len(order) != (len(o.Images) - len(external)) {
+			return errors.New("order must list every uploaded image exactly once")
+		}
+
+		for _, fileID := range order {
+			if uploaded[fileID] == 0 {
+				return errors.New("order lists an image that the User doesn't have")
+			}
+			if !o.OwnsFile(fileID) {
+				return errors.New("order lists an image that doesn't belong to the User")
+			}
+
+			uploaded[fileID]--
+			images = append(images, FileURL(fileID))
+		}
+		images = append(images, external...)
+	} else {
+		images = append(images, o.Images...)
+	}
+
+	// Move the primary image to the front
+	if primary != "" {
+		index := -1
+		for i, element := range images {
+			if fileID, ok := FileIDFromURL(element); ok && fileID == primary {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return errors.New("primary image is not one of the User's images")
+		}
+		if !o.OwnsFile(primary) {
+			return errors.New("primary image doesn't belong to the User")
+		}
+
+		images = append([]string{images[index]}, append(images[:index:index], images[(index+1):]...)...)
+	}
+
+	return o.SetImages(images)
+}
+
+// HideUnapprovedImages removes any uploaded images that haven't been approved
+// by moderation from the User's images. It should only be used on copies of
+// Users that are about to be returned by the API to other Users, never on ones
+// in the UserCache. (NOTE: If the moderation states can't be retrieved, every
+// uploaded image is removed, so that nothing unmoderated is ever shown.)
+func (o *User) HideUnapprovedImages() error {
+	fileIDs := []bson.ObjectId{}
+	for _, element := range o.Images {
+		if fileID, ok := FileIDFromURL(element); ok {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+
+	unapproved, err := GetUnapprovedFileIDs(fileIDs)
+
+	images := []string{}
+	for _, element := range o.Images {
+		if fileID, ok := FileIDFromURL(element); ok && (err != nil || unapproved[fileID]) {
+			continue
+		}
+
+		images = append(images, element)
+	}
+
+	o.Images = images
+
+	return err
+}
+
+// SignImages replaces the User's image URLs with ones signed for the User with
+// the provided ID to view. It should only be used on copies of Users that are
+// about to be returned by the API, never on ones in the UserCache.
+func (o *User) SignImages(viewerID int) {
+	images := []string{}
+	for _, element := range o.Images {
+		images = append(images, SignFileURL(element, viewerID))
+	}
+
+	o.Images = images
+}
+
+// SetImages replaces the User's images with the provided image URLs, keeping
+// the reference counts and ownership of the Files they point to up to date.
+// Any URLs pointing to Files that the User doesn't own (and didn't already
+// have) are dropped. The User still needs to be pushed afterwards.
+func (o *User) SetImages(images []string) error {
+	// Count up the references to each File before and after the change
+	oldCounts := map[bson.ObjectId]int{}
+	for _, element := range o.Images {
+		if fileID, ok := FileIDFromURL(element); ok {
+			oldCounts[fileID]++
+		}
+	}
+
+	// Don't let the User go over the maximum number of images (NOTE: Users
+	// that were already over it may still remove images.)
+	if len(images) > gMaxUserImages && len(images) > len(o.Images) {
+		return errors.New("User may not have more than " + strconv.Itoa(gMaxUserImages) + " images")
+	}
+
+	newImages := []string{}
+	newCounts := map[bson.ObjectId]int{}
+	for _, element := range images {
+		if fileID, ok := FileIDFromURL(element); ok {
+			if oldCounts[fileID] == 0 && !IsFileOwnedBy(fileID, o.ID) {
+				continue
+			}
+
+			// (NOTE: Clients are handed signed URLs, so strip the signature
+			// back off before storing them.)
+			element = FileURL(fileID)
+			newCounts[fileID]++
+		}
+
+		newImages = append(newImages, element)
+	}
+
+	// Update the reference counts of any Files that gained or lost references
+	for fileID, count := range newCounts {
+		if count > oldCounts[fileID] {
+			if err := RetainFile(fileID, (count - oldCounts[fileID])); err != nil {
+				return err
+			}
+		}
+	}
+	for fileID, count := range oldCounts {
+		if count > newCounts[fileID] {
+			if newCounts[fileID] == 0 {
+				if err := DisownFile(fileID, o.ID); err != nil {
+					return err
+				}
+			}
+
+			if err := ReleaseFile(fileID, (count - newCounts[fileID])); err != nil {
+				return err
+			}
+		}
+	}
+
+	o.Images = newImages
+
+	return nil
+}
+
+// UpdateAge recalculates the User's age from their birthdate, so that it is
+// correct even after their birthday.
+func (o *User) UpdateAge() {
+	if o.Birthdate == nil {
+		o.Age = 0
+		return
+	}
+
+	o.Age = AgeOn(*o.Birthdate, time.Now())
+}
+
+// SetBirthdate sets the User's birthdate, provided they are old enough to use
+// AKTVE. A birthdate from an identity provider (i.e. a verified one) can't be
+// replaced by one that the User has entered themselves.
+func (o *User) SetBirthdate(birthdate time.Time, verified bool) error {
+	if o.BirthdateVerified && !verified {
+		return errors.New("user's birthdate has already been verified")
+	}
+	if !IsOldEnough(birthdate) {
+		return errUserTooYoung
+	}
+
+	o.Birthdate = &birthdate
+	o.BirthdateVerified = verified
+	o.UpdateAge()
+
+	return nil
+}
+
+// IsUnderage returns whether the User is known to be younger than the minimum
+// age. (NOTE: Only a birthdate verified by an identity provider can be, as
+// Users can't enter one that is too young themselves.)
+func (o *User) IsUnderage() bool {
+	return o.Birthdate != nil && !IsOldEnough(*o.Birthdate)
+}
+
 // Push updates the User object in the database with its current local
 // representation.
 func (o *User) Push() error {
+	defer TimeDatabase("users", "update")()
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
 	// Update the User in the database
-	c := gDatabase.db.DB(dbDB).C("users")
+	c := db.DB(dbDB).C("users")
 	query := bson.M{"id": o.ID}
 	change := bson.M{"$set": o}
 	err := c.Update(query, change)
@@ -94,11 +471,16 @@ func (o *User) Push() error {
 // PullMatches updates the local User object with all of the actual Matches
 // between the given User and other Users.
 func (o *User) PullMatches() error {
+	defer TimeDatabase("likes", "find")()
+
 	var likes []Like
 	var like Like
 
+	db := gDatabase.Copy()
+	defer db.Close()
+
 	// Switch to the "likes" collection
-	c := gDatabase.db.DB(dbDB).C("likes")
+	c := db.DB(dbDB).C("likes")
 
 	// Retrieve all likes of the User
 	if err := c.Find(bson.M{"likee_id": o.ID}).All(&likes); err != nil {
@@ -135,58 +517,329 @@ func (o *User) DistanceFrom(latitude float32, longitude float32) float32 {
 	return (float32)(math.Sqrt(x + y))
 }
 
+// CreateUser creates a new User from the provided Identity (i.e. with the
+// name, images and birthdate that the IdentityProvider knows about), inserts
+// them into the database, and returns them. This is the only place that new
+// Users should be created, so that every User starts out with the same profile
+// defaults.
+func CreateUser(identity Identity) (User, error) {
+	// Refuse to create Users that we already know are too young
+	if identity.Birthdate != nil && !IsOldEnough(*identity.Birthdate) {
+		return User{}, errUserTooYoung
+	}
+
+	// Allocate this User's ID
+	id, err := NextID("users")
+	if err != nil {
+		return User{}, errors.New("failed to allocate user ID")
+	}
+
+	images := identity.ImageURLs
+	if images == nil {
+		images = []string{}
+	}
+
+	// Create the new User object
+	user := User{
+		ID:                id,
+		Name:              identity.Name,
+		Interests:         map[string]int{},
+		Tags:              []string{},
+		Bio:               "",
+		Images:            images,
+		Matches:           []Match{},
+		Latitude:          0,
+		Longitude:         0,
+		LastActive:        time.Now().String(),
+		ShareLocation:     true,
+		Birthdate:         identity.Birthdate,
+		BirthdateVerified: identity.Birthdate != nil && identity.BirthdateVerified,
+	}
+	user.UpdateAge()
+	user.UpdateOnboarding()
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	// Insert the User into the database
+	c := db.DB(dbDB).C("users")
+	done := TimeDatabase("users", "insert")
+	err = c.Insert(user)
+	done()
+	if err != nil {
+		return User{}, errors.New("failed to insert user into database")
+	}
+
+	gSignups.Inc()
+
+	return user, nil
+}
+
 // GetUser retrieves a copy of the User with the specified ID, along with their
 // associated UserCache index. If the User is not currently in the UserCache,
 // they are retrieved from the database and put into it. If no User is found,
 // an error is returned.
 func (o *UserCache) GetUser(userID int) (User, int, error) {
 	// Check the cache first to see if we already have a local copy of the User
+	o.mutex.Lock()
 	for index, element := range gUserCache.Users {
 		if element.ID == userID {
-			return element, index, nil
+			RecordCacheLookup("users", true)
+			gUserCache.Users[index].UpdateAge()
+			user := gUserCache.Users[index]
+			o.mutex.Unlock()
+			return user, index, nil
 		}
 	}
+	o.mutex.Unlock()
+	RecordCacheLookup("users", false)
 
 	// If not in the cache, check the database
 	var user User
 
-	c := gDatabase.db.DB(dbDB).C("users")
-	if err := c.Find(bson.M{"id": userID}).One(&user); err != nil {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("users")
+	done := TimeDatabase("users", "find")
+	err := c.Find(bson.M{"id": userID}).One(&user)
+	done()
+	if err != nil {
 		return User{}, -1, errors.New("could not find User with provided ID")
 	}
 
+	user.UpdateAge()
+
+	// (NOTE: The User may have been cached by someone else while they were
+	// being retrieved, in which case that copy is used.)
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for index, element := range gUserCache.Users {
+		if element.ID == userID {
+			return element, index, nil
+		}
+	}
 	gUserCache.Users = append(gUserCache.Users, user)
 
 	return user, (len(gUserCache.Users) - 1), nil
 }
 
+// ScheduleDeletion marks the User with the specified ID for deletion once the
+// account deletion grace period has passed, and returns the time that the
+// account will be deleted at. Until then, the deletion can be cancelled with
+// CancelDeletion.
+func (o *UserCache) ScheduleDeletion(userID int) (time.Time, error) {
+	_, userCacheIndex, err := o.GetUser(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Keep the original deletion date if the User is already scheduled for
+	// deletion
+	if o.Users[userCacheIndex].DeletionDate == nil {
+		deletionDate := time.Now().Add(gAccountDeletionGracePeriod)
+		o.Users[userCacheIndex].DeletionDate = &deletionDate
+	}
+
+	if err := o.Users[userCacheIndex].Push(); err != nil {
+		return time.Time{}, errors.New("failed to schedule User for deletion")
+	}
+
+	return *o.Users[userCacheIndex].DeletionDate, nil
+}
+
+// CancelDeletion cancels the scheduled deletion of the User with the specified
+// ID.
+func (o *UserCache) CancelDeletion(userID int) error {
+	_, userCacheIndex, err := o.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if o.Users[userCacheIndex].DeletionDate == nil {
+		return errors.New("User is not scheduled for deletion")
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	// (NOTE: Push won't remove the field since it is omitted when empty, so it
+	// has to be explicitly unset.)
+	c := db.DB(dbDB).C("users")
+	if err := c.Update(bson.M{"id": userID}, bson.M{"$unset": bson.M{"deletion_date": ""}}); err != nil {
+		return errors.New("failed to cancel User deletion")
+	}
+
+	o.Users[userCacheIndex].DeletionDate = nil
+
+	return nil
+}
+
+// Flush pushes the last active time of every User in the cache to the
+// database. (NOTE: Users are pushed as soon as they change, so this is just to
+// make sure that nothing is lost, e.g. when the API server shuts down. Nothing
+// else is pushed, as another API server may have changed the rest of the User
+// since it was cached, e.g. during a rolling deploy.)
+func (o *UserCache) Flush() error {
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("users")
+
+	o.mutex.Lock()
+	users := append([]User{}, o.Users...)
+	o.mutex.Unlock()
+
+	failed := 0
+	for _, element := range users {
+		if element.LastActive == "" {
+			continue
+		}
+
+		if err := c.Update(bson.M{"id": element.ID}, bson.M{"$set": bson.M{"last_active": element.LastActive}}); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errors.New("failed to push " + strconv.Itoa(failed) + " cached users")
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers deletes every User whose scheduled deletion date has
+// passed.
+func (o *UserCache) PurgeDeletedUsers() error {
+	var users []User
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	c := db.DB(dbDB).C("users")
+	if err := c.Find(bson.M{"deletion_date": bson.M{"$lte": time.Now()}}).All(&users); err != nil {
+		return errors.New("failed to find Users scheduled for deletion")
+	}
+
+	for _, element := range users {
+		if err := o.DeleteUser(element.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartDeletionWorker periodically purges Users whose scheduled deletion date
+// has passed, until the provided channel is closed. It should be run as a
+// goroutine.
+func (o *UserCache) StartDeletionWorker(interval time.Duration, stop <-chan struct{}) {
+	for {
+		if err := o.PurgeDeletedUsers(); err != nil {
+			slog.Error("There was an issue purging Users scheduled for deletion", "error", err)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+	}
+}
+
 // DeleteUser literally deletes the User with the specified ID from both the
-// local cache and the database. It should be used for account deletion.
+// local cache and the database, along with everything else that belongs to
+// them (Likes, files, sessions, email tokens and like quotas). Messages they
+// sent are kept for the other participant, but are anonymised. It should be
+// used for account deletion.
 func (o *UserCache) DeleteUser(userID int) error {
+	user, _, err := o.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
 	// Delete User from local cache
+	o.mutex.Lock()
 	for index, element := range gUserCache.Users {
 		if element.ID == userID {
 			o.Users = append(o.Users[:index], o.Users[(index+1):]...)
+			break
 		}
 	}
+	o.mutex.Unlock()
 
-	// Delete User from database
-	c := gDatabase.db.DB(dbDB).C("users")
-	if err := c.Remove(bson.M{"id": userID}); err != nil {
-		return errors.New("failed to remove user from database")
+	// Release all of the User's uploaded files (NOTE: The file garbage
+	// collector deletes them once nothing references them, i.e. unless someone
+	// else uploaded the same file.)
+	if err := user.SetImages([]string{}); err != nil {
+		return errors.New("failed to remove user's files from database")
+	}
+
+	db := gDatabase.Copy()
+	defer db.Close()
+
+	// Delete all of the User's Likes (both given and received) from database,
+	// which also removes all of their Matches
+	c := db.DB(dbDB).C("likes")
+	if _, err := c.RemoveAll(bson.M{"$or": []bson.M{{"liker_id": userID}, {"likee_id": userID}}}); err != nil {
+		return errors.New("failed to remove user's likes from database")
+	}
+
+	// Anonymise all of the User's Messages in the database (NOTE: The other
+	// participants' own Messages are left alone, and the conversations stay in
+	// their data exports.)
+	c = db.DB(dbDB).C("messages")
+	if _, err := c.UpdateAll(bson.M{"author_id": userID}, bson.M{"$set": bson.M{"author_id": deletedUserID}}); err != nil {
+		return errors.New("failed to anonymise user's messages in database")
+	}
+	if _, err := c.UpdateAll(bson.M{"participants": userID}, bson.M{"$set": bson.M{"participants.$": deletedUserID}}); err != nil {
+		return errors.New("failed to anonymise user's messages in database")
+	}
+
+	// Delete the User's daily like quotas from database
+	c = db.DB(dbDB).C("like_quotas")
+	if _, err := c.RemoveAll(bson.M{"user_id": userID}); err != nil {
+		return errors.New("failed to remove user's like quotas from database")
 	}
 
 	// Delete all of the User's social media links from database
-	c = gDatabase.db.DB(dbDB).C("fb_links")
-	if err := c.Remove(bson.M{"user_id": userID}); err != nil {
+	c = db.DB(dbDB).C("fb_links")
+	if _, err := c.RemoveAll(bson.M{"user_id": userID}); err != nil {
 		return errors.New("failed to remove user's Facebook links from database")
 	}
+	var links []IdentityLink
+	c = db.DB(dbDB).C("identity_links")
+	if err := c.Find(bson.M{"user_id": userID}).All(&links); err != nil {
+		return errors.New("failed to retrieve user's identity links")
+	}
+	if _, err := c.RemoveAll(bson.M{"user_id": userID}); err != nil {
+		return errors.New("failed to remove user's identity links from database")
+	}
+
+	// Delete any outstanding email tokens (e.g. login links) that were sent to
+	// the User's email addresses from database
+	emails := []string{}
+	for _, link := range links {
+		if link.Email != "" {
+			emails = append(emails, NormaliseEmail(link.Email))
+		}
+	}
+	c = db.DB(dbDB).C("email_tokens")
+	if _, err := c.RemoveAll(bson.M{"email": bson.M{"$in": emails}}); err != nil {
+		return errors.New("failed to remove user's email tokens from database")
+	}
 
-	// Delete all of the User's sessions from database
-	c = gDatabase.db.DB(dbDB).C("sessions")
-	if err := c.Remove(bson.M{"user_id": userID}); err != nil {
+	// Delete all of the User's sessions from database (and the session cache)
+	if err := gSessionCache.CleanSessions(userID); err != nil {
 		return errors.New("failed to remove user's sessions from database")
 	}
 
+	// Delete User from database
+	c = db.DB(dbDB).C("users")
+	if err := c.Remove(bson.M{"id": userID}); err != nil {
+		return errors.New("failed to remove user from database")
+	}
+
 	return nil
 }